@@ -0,0 +1,320 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/JSchlarb/artifact-downloader/pkg/downloader"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the file referenced by CONFIG_FILE.
+type fileConfig struct {
+	Owner         string                `yaml:"owner"`
+	Repository    string                `yaml:"repository"`
+	Tag           string                `yaml:"tag"`
+	DownloadPath  string                `yaml:"download-path"`
+	CheckInterval string                `yaml:"check-interval"`
+	CheckCron     string                `yaml:"check-cron"`
+	Artefacts     []downloader.Artefact `yaml:"artefacts"`
+	Sources       []fileSource          `yaml:"sources"`
+}
+
+// fileSource is an entry of the sources list of the config file.
+type fileSource struct {
+	Owner      string                `yaml:"owner"`
+	Repository string                `yaml:"repository"`
+	Tag        string                `yaml:"tag"`
+	Directory  string                `yaml:"directory"`
+	Artefacts  []downloader.Artefact `yaml:"artefacts"`
+	// Token replaces GITHUB_TOKEN or GITLAB_TOKEN for the source; TokenEnv
+	// names an environment variable holding it instead.
+	Token    string `yaml:"token"`
+	TokenEnv string `yaml:"token-env"`
+}
+
+// token returns the token of the source, if it has its own.
+func (s fileSource) token() string {
+	if s.TokenEnv != "" {
+		return os.Getenv(s.TokenEnv)
+	}
+	return s.Token
+}
+
+// loadConfigFile reads and validates a YAML config file.
+func loadConfigFile(filename string) (*fileConfig, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", filename, err)
+	}
+
+	if len(fc.Sources) > 0 && fc.Repository != "" {
+		return nil, fmt.Errorf("repository and sources are mutually exclusive in %s", filename)
+	}
+	if err := downloader.ValidateArtefacts(fc.Artefacts); err != nil {
+		return nil, fmt.Errorf("invalid artefacts in %s: %v", filename, err)
+	}
+	for _, src := range fc.Sources {
+		if src.Repository == "" {
+			return nil, fmt.Errorf("source without repository in %s", filename)
+		}
+		if src.Directory != "" && !filepath.IsLocal(src.Directory) {
+			return nil, fmt.Errorf("directory %q of source %s must be relative to the download path", src.Directory, src.Repository)
+		}
+		if err := downloader.ValidateArtefacts(src.Artefacts); err != nil {
+			return nil, fmt.Errorf("invalid artefacts of source %s in %s: %v", src.Repository, filename, err)
+		}
+		if src.Token != "" && src.TokenEnv != "" {
+			return nil, fmt.Errorf("token and token-env of source %s are mutually exclusive in %s", src.Repository, filename)
+		}
+		if src.TokenEnv != "" && os.Getenv(src.TokenEnv) == "" {
+			return nil, fmt.Errorf("token-env %s of source %s in %s is not set", src.TokenEnv, src.Repository, filename)
+		}
+	}
+	return &fc, nil
+}
+
+// configureSources returns the sources to download from and the artefacts of
+// those without their own. GITHUB_REPOSITORY takes precedence over the config
+// file and may list several repositories as owner/repo pairs, each of which
+// is downloaded into a subdirectory named after the repository, or after its
+// owner and name if repositories of different owners share the name.
+func configureSources(fc *fileConfig) ([]downloader.Source, []downloader.Artefact) {
+	owner := envOr("GITHUB_OWNER", fc.Owner)
+	tag := envOr("GITHUB_RELEASE_TAG", fc.Tag)
+	artefacts := fc.Artefacts
+	if v := os.Getenv("GITHUB_ARTEFACTS"); v != "" && !strings.HasPrefix(v, "@") {
+		artefacts = nil
+		for _, entry := range splitList(v) {
+			name, dest, _ := strings.Cut(entry, ":")
+			artefacts = append(artefacts, downloader.Artefact{Name: name, Dest: dest})
+		}
+	}
+
+	var sources []downloader.Source
+	if repos := os.Getenv("GITHUB_REPOSITORY"); repos != "" || len(fc.Sources) == 0 {
+		entries := splitList(cmp.Or(repos, fc.Repository))
+		if len(entries) == 0 {
+			// BASE_URL_TEMPLATE doesn't need a repository.
+			entries = []string{""}
+		}
+		names := make(map[string]int)
+		for _, entry := range entries {
+			src := downloader.Source{Owner: owner, Repo: entry, Tag: tag}
+			if o, r, ok := strings.Cut(entry, "/"); ok {
+				src.Owner, src.Repo = o, r
+			}
+			names[src.Repo]++
+			sources = append(sources, src)
+		}
+		if len(sources) > 1 {
+			for i, src := range sources {
+				// Repositories of the same name from different owners
+				// get a directory per owner.
+				sources[i].Directory = src.Repo
+				if names[src.Repo] > 1 {
+					sources[i].Directory = src.Owner + "/" + src.Repo
+				}
+			}
+		}
+	} else {
+		names := make(map[string]int)
+		for _, fs := range fc.Sources {
+			names[fs.Repository]++
+		}
+		for _, fs := range fc.Sources {
+			src := downloader.Source{
+				Owner:     cmp.Or(fs.Owner, owner),
+				Repo:      fs.Repository,
+				Tag:       cmp.Or(fs.Tag, tag),
+				Directory: fs.Directory,
+				Artefacts: fs.Artefacts,
+				Token:     fs.token(),
+			}
+			if src.Directory == "" {
+				src.Directory = src.Repo
+				if names[src.Repo] > 1 {
+					src.Directory = src.Owner + "/" + src.Repo
+				}
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources, artefacts
+}
+
+// envOr returns the environment variable key, or fallback if it is unset or empty.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// sizeUnits maps size suffixes to their multiplier, longest suffix first.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize parses a byte size such as "512KB", "5MB", or "1GiB".
+func parseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * multiplier), nil
+}
+
+// parseChecksums parses a comma-separated list of artefact=digest pairs.
+func parseChecksums(s string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		artefact, digest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid checksum entry %q; expected artefact=digest", entry)
+		}
+		artefact = strings.TrimSpace(artefact)
+		checksums[artefact] = strings.ToLower(strings.TrimSpace(digest))
+	}
+	return checksums, nil
+}
+
+// parseSymlinks parses a comma-separated list of artefact=link pairs, where
+// the artefact may be a glob pattern.
+func parseSymlinks(s string) (map[string]string, error) {
+	links := make(map[string]string)
+	for _, entry := range splitList(s) {
+		artefact, link, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(link) == "" {
+			return nil, fmt.Errorf("invalid symlink entry %q; expected artefact=path", entry)
+		}
+		links[strings.TrimSpace(artefact)] = strings.TrimSpace(link)
+	}
+	return links, nil
+}
+
+// parseHeaders parses a list of "Key: Value" pairs separated by commas or
+// newlines.
+func parseHeaders(s string) (http.Header, error) {
+	h := make(http.Header)
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q; expected Key: Value", line)
+		}
+		if http.CanonicalHeaderKey(name) == "User-Agent" {
+			return nil, fmt.Errorf("header %s is set with HTTP_USER_AGENT", name)
+		}
+		h.Add(name, strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+// netrcPath returns $NETRC, or .netrc in the home directory.
+func netrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// tracingFromEnv configures tracing from the standard OTEL_* environment
+// variables. It returns nil if no endpoint is set.
+func tracingFromEnv() (*downloader.Tracing, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	protocol := cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"), os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"))
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("unsupported protocol %q; only http/json is supported", protocol)
+	}
+
+	t := &downloader.Tracing{
+		Endpoint:       endpoint,
+		Headers:        make(map[string]string),
+		ServiceName:    os.Getenv("OTEL_SERVICE_NAME"),
+		ServiceVersion: version,
+	}
+	for _, h := range splitList(cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"), os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))) {
+		key, value, ok := strings.Cut(h, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q; expected key=value", h)
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		t.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return t, nil
+}
+
+// s3FromEnv configures the S3 backend from the S3_* and AWS_* environment
+// variables.
+func s3FromEnv() *downloader.S3 {
+	return &downloader.S3{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Prefix:          os.Getenv("S3_PREFIX"),
+		Region:          envOr("S3_REGION", os.Getenv("AWS_REGION")),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
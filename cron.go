@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard five-field cron expression
+// (minute, hour, day of month, month, day of week).
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Day of month and day of week match if either does, unless one starts
+	// with "*", like "*" or "*/2" do.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron parses a cron expression such as "0 */6 * * *".
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(cronFields), len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	// Both 0 and 7 mean Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges, and steps
+// into a bit set.
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepStr, f.name)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field", loStr, f.name)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s field", hiStr, f.name)
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", f.name, part, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t that matches the schedule.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression matches at least once within a few years.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return limit
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
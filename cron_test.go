@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "step of hours", expr: "0 */6 * * *", from: date(2024, 1, 1, 1, 30), want: date(2024, 1, 1, 6, 0)},
+		{name: "strictly after", expr: "0 */6 * * *", from: date(2024, 1, 1, 6, 0), want: date(2024, 1, 1, 12, 0)},
+		{name: "next day", expr: "30 2 * * *", from: date(2024, 1, 1, 3, 0), want: date(2024, 1, 2, 2, 30)},
+		{name: "month rollover", expr: "0 0 1 * *", from: date(2024, 1, 31, 12, 0), want: date(2024, 2, 1, 0, 0)},
+		{name: "year rollover", expr: "0 0 1 1 *", from: date(2024, 6, 1, 0, 0), want: date(2025, 1, 1, 0, 0)},
+		{name: "leap day", expr: "0 0 29 2 *", from: date(2025, 1, 1, 0, 0), want: date(2028, 2, 29, 0, 0)},
+		{name: "day of month or week matches", expr: "0 0 13 * 5", from: date(2024, 1, 1, 0, 0), want: date(2024, 1, 5, 0, 0)},
+		{name: "day of month or week matches on the 13th", expr: "0 0 13 * 5", from: date(2024, 1, 12, 0, 0), want: date(2024, 1, 13, 0, 0)},
+		{name: "step of days restricts day of week", expr: "0 0 */2 * 1", from: date(2024, 1, 1, 0, 0), want: date(2024, 1, 15, 0, 0)},
+		{name: "step of weekdays restricts day of month", expr: "0 0 10 * */7", from: date(2024, 1, 1, 0, 0), want: date(2024, 3, 10, 0, 0)},
+		{name: "sunday as 7", expr: "0 0 * * 7", from: date(2024, 1, 1, 0, 0), want: date(2024, 1, 7, 0, 0)},
+		{name: "list and range", expr: "15,45 9-10 * * 1-5", from: date(2024, 1, 5, 10, 50), want: date(2024, 1, 8, 9, 15)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%v) of %q = %v, want %v", tt.from, tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -1,3 +1,14 @@
 module github.com/JSchlarb/artifact-downloader
 
 go 1.23.4
+
+require (
+	github.com/ProtonMail/go-crypto v1.5.1
+	github.com/klauspost/compress v1.17.11
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.35.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/cloudflare/circl v1.6.3 // indirect
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// health serves the liveness and readiness probes. The downloader becomes
+// ready after its first successful check, and with REQUIRE_ALL is unready
+// again after every failed check.
+type health struct {
+	ready atomic.Bool
+}
+
+func (h *health) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// jitter is a window of random delays added to the scheduled checks, so that
+// many instances started at the same time don't all check at once.
+type jitter struct {
+	min, max time.Duration
+}
+
+// parseJitter parses a window such as "0-300s" or "30s-5m". A single duration
+// is the same as a window starting at zero.
+func parseJitter(s string) (jitter, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		lo, hi = "0", s
+	}
+	var j jitter
+	var err error
+	if j.min, err = time.ParseDuration(lo); err != nil {
+		return jitter{}, err
+	}
+	if j.max, err = time.ParseDuration(hi); err != nil {
+		return jitter{}, err
+	}
+	if j.min < 0 || j.max < j.min {
+		return jitter{}, fmt.Errorf("invalid window %s to %s", j.min, j.max)
+	}
+	return j, nil
+}
+
+// delay returns a random duration within the window.
+func (j jitter) delay() time.Duration {
+	if j.max == j.min {
+		return j.min
+	}
+	return j.min + rand.N(j.max-j.min+1)
+}
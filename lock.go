@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockFileName is the name of the lock file in DOWNLOAD_PATH. The lock is
+// held with flock or LockFileEx, which the operating system releases when
+// the process dies, so a crashed instance never leaves a stale lock behind.
+const lockFileName = ".lock"
+
+// lockPollInterval is the time between attempts to take the lock with
+// LOCK_MODE=wait, and defaultLockTimeout the longest wait unless
+// LOCK_TIMEOUT is set.
+const (
+	lockPollInterval   = time.Second
+	defaultLockTimeout = 5 * time.Minute
+)
+
+// acquireLock locks the lock file in dir, waiting up to wait for another
+// instance to release it, and writes our PID into it.
+func acquireLock(dir string, wait time.Duration) (*os.File, error) {
+	path := filepath.Join(dir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for logged := false; ; {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		holder := lockHolder(path)
+		if wait <= 0 || time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another instance (%s) is using %s", holder, dir)
+		}
+		if !logged {
+			slog.Info("Waiting for another instance to release the lock", "event", "lock", "path", path,
+				"holder", holder, "timeout", wait)
+			logged = true
+		}
+		time.Sleep(min(lockPollInterval, time.Until(deadline)))
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return f, nil
+}
+
+// lockHolder describes the process holding the lock file at path.
+func lockHolder(path string) string {
+	data, _ := os.ReadFile(path)
+	if pid := strings.TrimSpace(string(data)); pid != "" {
+		return "PID " + pid
+	}
+	return "unknown PID"
+}
+
+// releaseLock releases the lock. The file is kept, since removing it would
+// let a waiting instance lock a file that a third one has already replaced.
+func releaseLock(f *os.File) {
+	if err := unlock(f); err != nil {
+		slog.Warn("Failed to release lock file", "path", f.Name(), "error", err)
+	}
+	f.Close()
+}
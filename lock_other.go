@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLock(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func unlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
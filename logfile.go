@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is the log file of LOG_FILE. Once a write would grow it beyond
+// maxSize, it is renamed to path.1, older backups are shifted to path.2 and
+// so on up to maxBackups, and a new file is started. Writes aren't buffered,
+// so nothing is lost if the process exits without closing the file.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+// openRotatingFile opens path for appending, creating it if needed. A maxSize
+// of zero disables rotation.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open starts appending to the file at path.
+func (r *rotatingFile) open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing records.
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", r.path, err)
+			if r.f == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file to the first backup and starts a new one. If
+// the new file can't be opened, the current one is reopened wherever it ended
+// up, so that writes continue to it; only if that fails too is the file left
+// closed.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	current := r.path
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if os.Rename(r.path, r.path+".1") == nil {
+			current = r.path + ".1"
+		}
+	}
+	if err := r.open(r.path); err != nil {
+		if current == r.path || r.open(current) != nil {
+			r.f = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Close syncs and closes the file. It is a no-op on a nil file, so that it
+// can be called whether or not LOG_FILE is set.
+func (r *rotatingFile) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	r.f.Sync()
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// setupLogging configures the default logger to write to out for the given
+// LOG_FORMAT, LOG_LEVEL, and LOG_TZ. The text format keeps the standard logger's output,
+// json emits one JSON record per line. Both stamp records with RFC 3339
+// timestamps in the time zone, which defaults to UTC. Calls to the log
+// package are routed through the same handler.
+func setupLogging(out io.Writer, format, level, tz string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+			return fmt.Errorf("unsupported log level %q; expected debug, info, warn, or error", level)
+		}
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", tz)
+	}
+
+	switch format {
+	case "", "text":
+		log.SetFlags(0)
+		log.SetOutput(&timestampWriter{w: out, loc: loc})
+		slog.SetLogLoggerLevel(lvl)
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
+			Level: lvl,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					a.Value = slog.TimeValue(a.Value.Time().In(loc))
+				}
+				return a
+			},
+		})))
+		return nil
+	default:
+		return fmt.Errorf("unsupported log format %q; expected text or json", format)
+	}
+}
+
+// timestampWriter prefixes every line of the standard logger with the
+// current time in loc.
+type timestampWriter struct {
+	w   io.Writer
+	loc *time.Location
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	line := append([]byte(time.Now().In(t.loc).Format(time.RFC3339)+" "), p...)
+	if _, err := t.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,170 +1,660 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
-)
 
-var (
-	client *http.Client
-	buffer = make([]byte, 32*1024)
+	"github.com/JSchlarb/artifact-downloader/pkg/downloader"
 )
 
-func download(url, artefact, downloadPath string) error {
-	localFilePath := filepath.Join(downloadPath, artefact)
-	log.Printf("Processing artefact: %s", artefact)
+// waitPollInterval is the time between checks while waiting for a release
+// with WAIT_FOR_RELEASE.
+const waitPollInterval = 15 * time.Second
 
-	needDownload := true
-	if fi, err := os.Stat(localFilePath); err == nil {
-		localModTime := fi.ModTime()
+// defaultInitWait is the WAIT_FOR_RELEASE of INIT_MODE unless it is set.
+const defaultInitWait = 10 * time.Minute
 
-		req, err := http.NewRequest("HEAD", url, nil)
+func main() {
+	if printVersion() {
+		fmt.Println(versionInfo())
+		return
+	}
+
+	// Closing the log file is safe while it is nil, so it need not be
+	// guarded on the exit paths below.
+	var logOutput io.Writer = os.Stderr
+	var logFile *rotatingFile
+	if v := os.Getenv("LOG_FILE"); v != "" {
+		maxSize, err := parseSize(envOr("LOG_MAX_SIZE", "100MiB"))
 		if err != nil {
-			return fmt.Errorf("error creating HEAD request for %s: %v", url, err)
+			log.Fatalf("Invalid LOG_MAX_SIZE %q; error: %v", os.Getenv("LOG_MAX_SIZE"), err)
+		}
+		maxBackups, err := strconv.Atoi(envOr("LOG_MAX_BACKUPS", "5"))
+		if err != nil || maxBackups < 0 {
+			log.Fatalf("Invalid LOG_MAX_BACKUPS %q; must be a non-negative integer", os.Getenv("LOG_MAX_BACKUPS"))
+		}
+		if logFile, err = openRotatingFile(v, maxSize, maxBackups); err != nil {
+			log.Fatalf("Invalid LOG_FILE %q; error: %v", v, err)
 		}
-		resp, err := client.Do(req)
+		defer logFile.Close()
+		logOutput = logFile
+	}
+	if err := setupLogging(logOutput, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"), os.Getenv("LOG_TZ")); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+
+	fc := &fileConfig{}
+	if v := os.Getenv("CONFIG_FILE"); v != "" {
+		var err error
+		if fc, err = loadConfigFile(v); err != nil {
+			log.Fatalf("Invalid CONFIG_FILE %q; error: %v", v, err)
+		}
+		slog.Info("Loaded config file", "path", v, "artefacts", len(fc.Artefacts))
+	}
+
+	// Environment variables take precedence over the config file.
+	opts := downloader.DefaultOptions()
+	opts.DownloadPath = envOr("DOWNLOAD_PATH", fc.DownloadPath)
+	opts.PostDownloadHook = os.Getenv("POST_DOWNLOAD_HOOK")
+	opts.LookupVariable = os.LookupEnv
+	opts.BasicUser, opts.BasicPass = os.Getenv("HTTP_BASIC_USER"), os.Getenv("HTTP_BASIC_PASS")
+	if v := os.Getenv("HTTP_HEADERS"); v != "" {
+		h, err := parseHeaders(v)
+		if err != nil {
+			log.Fatalf("Invalid HTTP_HEADERS; error: %v", err)
+		}
+		opts.Headers = h
+	}
+	if v := os.Getenv("USE_NETRC"); v != "" {
+		useNetrc, err := strconv.ParseBool(v)
 		if err != nil {
-			return fmt.Errorf("error performing HEAD request for %s: %v", url, err)
-		}
-		resp.Body.Close()
-
-		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
-			remoteModTime, err := time.Parse(http.TimeFormat, lastModified)
-			if err != nil {
-				log.Printf("Error parsing Last-Modified header for %s: %v", url, err)
-			} else if !remoteModTime.After(localModTime) {
-				log.Printf("No new version available for %s (remote: %s, local: %s)",
-					artefact, remoteModTime, localModTime)
-				needDownload = false
+			log.Fatalf("Invalid USE_NETRC %q; error: %v", v, err)
+		}
+		if useNetrc {
+			if opts.NetrcFile, err = netrcPath(); err != nil {
+				log.Fatalf("Failed to read netrc file: %v", err)
 			}
-		} else {
-			log.Printf("No Last-Modified header for %s; proceeding to download", url)
 		}
 	}
 
-	if needDownload {
-		log.Printf("Downloading %s from %s", artefact, url)
-		resp, err := client.Get(url)
+	switch opts.Provider = envOr("PROVIDER", "github"); opts.Provider {
+	case "github":
+		opts.GitHubToken = os.Getenv("GITHUB_TOKEN")
+		var err error
+		if v := os.Getenv("INCLUDE_PRERELEASES"); v != "" {
+			if opts.IncludePrereleases, err = strconv.ParseBool(v); err != nil {
+				log.Fatalf("Invalid INCLUDE_PRERELEASES %q; error: %v", v, err)
+			}
+		}
+		if v := os.Getenv("INCLUDE_DRAFTS"); v != "" {
+			if opts.IncludeDrafts, err = strconv.ParseBool(v); err != nil {
+				log.Fatalf("Invalid INCLUDE_DRAFTS %q; error: %v", v, err)
+			}
+		}
+		opts.VersionConstraint = os.Getenv("VERSION_CONSTRAINT")
+	case "gitlab":
+		opts.GitLabBaseURL = envOr("GITLAB_BASE_URL", opts.GitLabBaseURL)
+		opts.GitLabToken = os.Getenv("GITLAB_TOKEN")
+	default:
+		log.Fatalf("Invalid PROVIDER %q; expected github or gitlab", opts.Provider)
+	}
+	opts.Sources, opts.Artefacts = configureSources(fc)
+	if v := os.Getenv("DOWNLOAD_ALL"); v != "" {
+		downloadAll, err := strconv.ParseBool(v)
 		if err != nil {
-			return fmt.Errorf("error downloading %s: %v", artefact, err)
+			log.Fatalf("Invalid DOWNLOAD_ALL %q; error: %v", v, err)
 		}
-		defer resp.Body.Close()
+		if downloadAll && (os.Getenv("GITHUB_ARTEFACTS") != "" || os.Getenv("ARTEFACTS_FILE") != "") {
+			log.Fatalf("DOWNLOAD_ALL can't be combined with GITHUB_ARTEFACTS or ARTEFACTS_FILE")
+		}
+		if downloadAll {
+			opts.Artefacts = []downloader.Artefact{{Name: "*"}}
+			for i := range opts.Sources {
+				opts.Sources[i].Artefacts = nil
+			}
+		}
+	}
+	opts.ArtefactsFile = os.Getenv("ARTEFACTS_FILE")
+	if v, ok := strings.CutPrefix(os.Getenv("GITHUB_ARTEFACTS"), "@"); ok {
+		if opts.ArtefactsFile != "" {
+			log.Fatalf("ARTEFACTS_FILE and GITHUB_ARTEFACTS=@file are mutually exclusive")
+		}
+		opts.ArtefactsFile = v
+	}
+	opts.Exclude = splitList(os.Getenv("EXCLUDE"))
+	if v, ok := strings.CutPrefix(os.Getenv("DISABLED_ARTEFACTS"), "@"); ok {
+		opts.DisabledFile = v
+	} else {
+		opts.Disabled = splitList(os.Getenv("DISABLED_ARTEFACTS"))
+	}
+	opts.URLTemplate = os.Getenv("BASE_URL_TEMPLATE")
+	if opts.DownloadPath == "" {
+		log.Fatal("Missing required environment variables. Ensure GITHUB_ARTEFACTS and DOWNLOAD_PATH are set.")
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to download %s: HTTP status %s", artefact, resp.Status)
+	var err error
+	checkIntervalStr := os.Getenv("CHECK_INTERVAL")
+	checkCron := os.Getenv("CHECK_CRON")
+	if checkIntervalStr == "" && checkCron == "" {
+		// The schedule of the config file only applies if the environment sets none.
+		checkIntervalStr, checkCron = fc.CheckInterval, fc.CheckCron
+	}
+	var initMode bool
+	if v := os.Getenv("INIT_MODE"); v != "" {
+		if initMode, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid INIT_MODE %q; error: %v", v, err)
 		}
+	}
+	runOnce := false
+	checkInterval := time.Hour
+	var schedule func(time.Time) time.Time
+	// Artefacts with their own interval need a check at least that often.
+	var shortest time.Duration
+	artefacts := opts.Artefacts
+	for _, src := range opts.Sources {
+		artefacts = append(artefacts, src.Artefacts...)
+	}
+	for _, a := range artefacts {
+		if a.Interval > 0 && (shortest == 0 || a.Interval < shortest) {
+			shortest = a.Interval
+		}
+	}
 
-		tmpFile := filepath.Join(downloadPath, fmt.Sprintf(".tmp-%s", artefact))
-		out, err := os.Create(tmpFile)
+	if initMode {
+		runOnce = true
+		slog.Info("Init mode enabled; running until all artefacts are present and verified, then exiting")
+	} else if checkCron != "" {
+		cron, err := parseCron(checkCron)
 		if err != nil {
-			os.Remove(tmpFile)
-			return fmt.Errorf("error creating file %s: %v", tmpFile, err)
+			log.Fatalf("Invalid CHECK_CRON %q; error: %v", checkCron, err)
+		}
+		if checkIntervalStr != "" {
+			slog.Info("Both CHECK_CRON and CHECK_INTERVAL are set; using CHECK_CRON")
+		}
+		slog.Info("Using cron schedule", "cron", checkCron)
+		schedule = cron.next
+	} else if (checkIntervalStr == "" || checkIntervalStr == "0") && shortest == 0 {
+		runOnce = true
+		slog.Info("Check interval set to 0 or empty; running only once")
+	} else {
+		if checkIntervalStr == "" || checkIntervalStr == "0" {
+			checkInterval = shortest
+		} else if checkInterval, err = time.ParseDuration(checkIntervalStr); err != nil {
+			log.Fatalf("Invalid CHECK_INTERVAL %q; error: %v", checkIntervalStr, err)
+		}
+		if shortest > 0 && shortest < checkInterval {
+			slog.Info("Checking at the shortest artefact interval", "check_interval", checkInterval, "interval", shortest)
+			checkInterval = shortest
+		}
+		slog.Info("Using fixed check interval", "interval", checkInterval)
+		schedule = func(t time.Time) time.Time { return t.Add(checkInterval) }
+	}
+	opts.FailureBackoff = !runOnce
+	var checkJitter jitter
+	if v := os.Getenv("CHECK_JITTER"); v != "" {
+		if checkJitter, err = parseJitter(v); err != nil {
+			log.Fatalf("Invalid CHECK_JITTER %q; error: %v", v, err)
 		}
+	}
+
+	if opts.Checksums, err = parseChecksums(os.Getenv("GITHUB_CHECKSUMS")); err != nil {
+		log.Fatalf("Invalid GITHUB_CHECKSUMS: %v", err)
+	}
 
-		if _, err := io.CopyBuffer(out, resp.Body, buffer); err != nil {
-			out.Close()
-			return fmt.Errorf("error saving file %s: %v", tmpFile, err)
+	opts.SigningPublicKey = os.Getenv("SIGNING_PUBLIC_KEY")
+	opts.SignatureType = envOr("SIGNATURE_TYPE", opts.SignatureType)
+
+	if v := os.Getenv("CHECKSUM_COMPANION"); v != "" {
+		if opts.ChecksumCompanion, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid CHECKSUM_COMPANION %q; error: %v", v, err)
 		}
-		out.Close()
-		log.Printf("Successfully downloaded %s", artefact)
+	}
 
-		if err := os.Rename(tmpFile, localFilePath); err != nil {
-			return fmt.Errorf("error moving file %s to %s: %v", tmpFile, localFilePath, err)
+	opts.ChecksumManifest = os.Getenv("CHECKSUM_MANIFEST")
+	if v := os.Getenv("CHECKSUM_RETRIES"); v != "" {
+		if opts.ChecksumRetries, err = strconv.Atoi(v); err != nil || opts.ChecksumRetries < 0 {
+			log.Fatalf("Invalid CHECKSUM_RETRIES %q; must be a non-negative integer", v)
 		}
-		log.Printf("Moved tmp file %s to %s", tmpFile, localFilePath)
+	}
+	opts.GPGPublicKey = os.Getenv("GPG_PUBLIC_KEY")
+	opts.CASDir = os.Getenv("CAS_DIR")
+	if v := os.Getenv("VERIFY_ON_START"); v != "" {
+		if opts.VerifyOnStart, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid VERIFY_ON_START %q; error: %v", v, err)
+		}
+	}
+	opts.VerifyOnStart = opts.VerifyOnStart || initMode
+	if v := os.Getenv("WRITE_MANIFEST"); v != "" {
+		if opts.WriteManifest, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid WRITE_MANIFEST %q; error: %v", v, err)
+		}
+	}
 
-		if lm := resp.Header.Get("Last-Modified"); lm != "" {
-			if remoteModTime, err := time.Parse(http.TimeFormat, lm); err == nil {
-				if err := os.Chtimes(localFilePath, time.Now(), remoteModTime); err != nil {
-					return fmt.Errorf("error updating mod time for %s: %v", artefact, err)
-				}
-			} else {
-				return fmt.Errorf("error parsing Last-Modified header for %s: %v", artefact, err)
-			}
+	if opts.Symlinks, err = parseSymlinks(os.Getenv("SYMLINK_LATEST")); err != nil {
+		log.Fatalf("Invalid SYMLINK_LATEST: %v", err)
+	}
+
+	if v := os.Getenv("PROGRESS"); v != "" {
+		if opts.Progress, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid PROGRESS %q; error: %v", v, err)
 		}
 	}
-	return nil
-}
 
-func checkAndDownload(owner, repo, artefacts, downloadPath string) {
-	if err := os.MkdirAll(downloadPath, 0755); err != nil {
-		log.Printf("Failed to create download directory %q: %v", downloadPath, err)
-		return
+	if v := os.Getenv("REQUIRE_ALL"); v != "" {
+		if opts.RequireAll, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid REQUIRE_ALL %q; error: %v", v, err)
+		}
 	}
+	opts.RequireAll = opts.RequireAll || initMode
 
-	for _, artefact := range strings.Split(artefacts, ",") {
-		artefact = strings.TrimSpace(artefact)
-		if artefact == "" {
-			continue
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid DRY_RUN %q; error: %v", v, err)
 		}
-		url := fmt.Sprintf("https://github.com/%s/%s/releases/latest/download/%s", owner, repo, artefact)
-		if err := download(url, artefact, downloadPath); err != nil {
-			log.Printf("Failed to download artefact %s: %v", artefact, err)
+		if opts.DryRun && initMode {
+			log.Fatalf("DRY_RUN can't be combined with INIT_MODE")
 		}
 	}
-}
 
-func main() {
-	owner := os.Getenv("GITHUB_OWNER")
-	repo := os.Getenv("GITHUB_REPOSITORY")
-	artefacts := os.Getenv("GITHUB_ARTEFACTS")
-	downloadPath := os.Getenv("DOWNLOAD_PATH")
+	if v := os.Getenv("RESUME_DOWNLOADS"); v != "" {
+		if opts.Resume, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid RESUME_DOWNLOADS %q; error: %v", v, err)
+		}
+	}
 
-	if owner == "" || repo == "" || artefacts == "" || downloadPath == "" {
-		log.Fatal("Missing required environment variables. Ensure GITHUB_OWNER, GITHUB_REPOSITORY, GITHUB_ARTEFACTS, and DOWNLOAD_PATH are set.")
+	if v := os.Getenv("REJECT_HTML"); v != "" {
+		if opts.RejectHTML, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid REJECT_HTML %q; error: %v", v, err)
+		}
 	}
 
-	checkIntervalStr := os.Getenv("CHECK_INTERVAL")
-	runOnce := false
-	checkInterval := time.Hour
+	if v := os.Getenv("KEEP_BACKUP"); v != "" {
+		if opts.KeepBackup, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid KEEP_BACKUP %q; error: %v", v, err)
+		}
+	}
 
-	if checkIntervalStr == "" || checkIntervalStr == "0" {
-		runOnce = true
-		log.Println("Check interval set to 0 or empty; running only once")
-	} else {
-		var err error
-		checkInterval, err = time.ParseDuration(checkIntervalStr)
+	if v := os.Getenv("EXTRACT"); v != "" {
+		if opts.Extract, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid EXTRACT %q; error: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("KEEP_ARCHIVE"); v != "" {
+		if opts.KeepArchive, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid KEEP_ARCHIVE %q; error: %v", v, err)
+		}
+	}
+
+	switch v := os.Getenv("MAKE_EXECUTABLE"); strings.ToLower(v) {
+	case "", "false":
+	case "true":
+		opts.Executables = []string{"*"}
+	default:
+		opts.Executables = splitList(v)
+	}
+
+	if v := os.Getenv("KEEP_VERSIONS"); v != "" {
+		if opts.KeepVersions, err = strconv.Atoi(v); err != nil || opts.KeepVersions < 0 {
+			log.Fatalf("Invalid KEEP_VERSIONS %q; must be a non-negative integer", v)
+		}
+	}
+
+	if v := os.Getenv("DOWNLOAD_CONCURRENCY"); v != "" {
+		if opts.Concurrency, err = strconv.Atoi(v); err != nil || opts.Concurrency < 1 {
+			log.Fatalf("Invalid DOWNLOAD_CONCURRENCY %q; must be a positive integer", v)
+		}
+	}
+	if v := os.Getenv("PARALLEL_CHUNKS"); v != "" {
+		if opts.ParallelChunks, err = strconv.Atoi(v); err != nil || opts.ParallelChunks < 1 || opts.ParallelChunks > downloader.MaxParallelChunks {
+			log.Fatalf("Invalid PARALLEL_CHUNKS %q; must be between 1 and %d", v, downloader.MaxParallelChunks)
+		}
+	}
+
+	if v := os.Getenv("MIN_RELEASE_AGE"); v != "" {
+		if opts.MinReleaseAge, err = time.ParseDuration(v); err != nil || opts.MinReleaseAge < 0 {
+			log.Fatalf("Invalid MIN_RELEASE_AGE %q; must be a non-negative duration", v)
+		}
+	}
+	switch freshness := envOr("FRESHNESS", "time"); freshness {
+	case "time":
+	case "content":
+		opts.ContentFreshness = true
+	default:
+		log.Fatalf("Invalid FRESHNESS %q; expected time or content", freshness)
+	}
+	opts.MtimeSource = envOr("MTIME_SOURCE", opts.MtimeSource)
+	if v := os.Getenv("MAX_MOD_TIME_SKEW"); v != "" {
+		if opts.MaxModTimeSkew, err = time.ParseDuration(v); err != nil || opts.MaxModTimeSkew < 0 {
+			log.Fatalf("Invalid MAX_MOD_TIME_SKEW %q; must be a non-negative duration", v)
+		}
+	}
+	if v := os.Getenv("REDOWNLOAD_ON_SKEW"); v != "" {
+		if opts.RedownloadOnSkew, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid REDOWNLOAD_ON_SKEW %q; error: %v", v, err)
+		}
+	}
+
+	opts.NotifyWebhookURL = os.Getenv("NOTIFY_WEBHOOK_URL")
+	opts.NotifyOn = envOr("NOTIFY_ON", opts.NotifyOn)
+	opts.SlackWebhookURL = os.Getenv("SLACK_WEBHOOK_URL")
+
+	if v := os.Getenv("DOWNLOAD_MAX_RETRIES"); v != "" {
+		if opts.MaxRetries, err = strconv.Atoi(v); err != nil || opts.MaxRetries < 0 {
+			log.Fatalf("Invalid DOWNLOAD_MAX_RETRIES %q; must be a non-negative integer", v)
+		}
+	}
+
+	if v := os.Getenv("MAX_BANDWIDTH"); v != "" {
+		if opts.MaxBandwidth, err = parseSize(v); err != nil {
+			log.Fatalf("Invalid MAX_BANDWIDTH %q; error: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("DISK_SPACE_MARGIN"); v != "" {
+		if opts.DiskSpaceMargin, err = parseSize(v); err != nil {
+			log.Fatalf("Invalid DISK_SPACE_MARGIN %q; error: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("MAX_ARTEFACT_SIZE"); v != "" {
+		if opts.MaxArtefactSize, err = parseSize(v); err != nil || opts.MaxArtefactSize < 0 {
+			log.Fatalf("Invalid MAX_ARTEFACT_SIZE %q; must be a size such as 2GB", v)
+		}
+	}
+
+	if v := os.Getenv("FILE_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("Invalid FILE_MODE %q; must be an octal permission such as 0644", v)
+		}
+		opts.FileMode = fs.FileMode(mode)
+	}
+	if v := os.Getenv("DIR_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("Invalid DIR_MODE %q; must be an octal permission such as 0755", v)
+		}
+		opts.DirMode = fs.FileMode(mode)
+	}
+
+	if v := os.Getenv("COPY_BUFFER_SIZE"); v != "" {
+		size, err := parseSize(v)
+		if err != nil || size < 512 || size > 64<<20 {
+			log.Fatalf("Invalid COPY_BUFFER_SIZE %q; must be a size between 512 bytes and 64MB", v)
+		}
+		opts.CopyBufferSize = int(size)
+	}
+
+	if v := os.Getenv("HTTP_TIMEOUT"); v != "" {
+		if opts.HTTPTimeout, err = time.ParseDuration(v); err != nil || opts.HTTPTimeout <= 0 {
+			log.Fatalf("Invalid HTTP_TIMEOUT %q; must be a positive duration", v)
+		}
+	}
+
+	if v := os.Getenv("RATE_LIMIT_MAX_WAIT"); v != "" {
+		if opts.RateLimitMaxWait, err = time.ParseDuration(v); err != nil || opts.RateLimitMaxWait < 0 {
+			log.Fatalf("Invalid RATE_LIMIT_MAX_WAIT %q; must be a non-negative duration", v)
+		}
+	}
+	if v := os.Getenv("RETRY_AFTER_MAX_WAIT"); v != "" {
+		if opts.RetryAfterMaxWait, err = time.ParseDuration(v); err != nil || opts.RetryAfterMaxWait < 0 {
+			log.Fatalf("Invalid RETRY_AFTER_MAX_WAIT %q; must be a non-negative duration", v)
+		}
+	}
+
+	if v := os.Getenv("MAX_REDIRECTS"); v != "" {
+		if opts.MaxRedirects, err = strconv.Atoi(v); err != nil || opts.MaxRedirects < 0 {
+			log.Fatalf("Invalid MAX_REDIRECTS %q; must be a non-negative integer", v)
+		}
+	}
+
+	opts.TLS = downloader.TLS{
+		CAFile:        os.Getenv("TLS_CA_FILE"),
+		ClientCert:    os.Getenv("TLS_CLIENT_CERT"),
+		ClientKey:     os.Getenv("TLS_CLIENT_KEY"),
+		InsecureHosts: splitList(os.Getenv("TLS_INSECURE_HOSTS")),
+	}
+	if v := os.Getenv("TLS_INSECURE_SKIP_VERIFY"); v != "" {
+		if opts.TLS.InsecureSkipVerify, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid TLS_INSECURE_SKIP_VERIFY %q; error: %v", v, err)
+		}
+	}
+
+	opts.UserAgent = envOr("HTTP_USER_AGENT", "artifact-downloader/"+version)
+	if v := os.Getenv("DISABLE_AUTO_DECOMPRESS"); v != "" {
+		if opts.DisableDecompression, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid DISABLE_AUTO_DECOMPRESS %q; error: %v", v, err)
+		}
+	}
+
+	// Honor HTTP_PROXY, HTTPS_PROXY, and NO_PROXY unless DOWNLOAD_PROXY is set.
+	if v := os.Getenv("DOWNLOAD_PROXY"); v != "" {
+		proxyURL, err := url.Parse(v)
+		if err != nil || proxyURL.Host == "" {
+			log.Fatalf("Invalid DOWNLOAD_PROXY %q; expected a URL such as http://proxy:3128 or socks5://proxy:1080", v)
+		}
+		slog.Info("Using proxy for all requests", "proxy", proxyURL.Redacted())
+		opts.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	opts.EventSocket = os.Getenv("EVENT_SOCKET")
+	if opts.Tracing, err = tracingFromEnv(); err != nil {
+		log.Fatalf("Invalid OpenTelemetry configuration: %v", err)
+	}
+
+	switch backend := envOr("STORAGE_BACKEND", "local"); backend {
+	case "local":
+	case "s3":
+		opts.S3 = s3FromEnv()
+	default:
+		log.Fatalf("Invalid STORAGE_BACKEND %q; expected local or s3", backend)
+	}
+
+	var waitForRelease time.Duration
+	if initMode {
+		waitForRelease = defaultInitWait
+	}
+	if v := os.Getenv("WAIT_FOR_RELEASE"); v != "" {
+		if waitForRelease, err = time.ParseDuration(v); err != nil || waitForRelease < 0 {
+			log.Fatalf("Invalid WAIT_FOR_RELEASE %q; must be a non-negative duration", v)
+		}
+		if !runOnce {
+			slog.Info("WAIT_FOR_RELEASE only applies in run once mode; ignoring it")
+		}
+	}
+
+	var failOnStartup bool
+	if v := os.Getenv("FAIL_ON_STARTUP"); v != "" {
+		if failOnStartup, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid FAIL_ON_STARTUP %q; error: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("TEMP_FILE_MAX_AGE"); v != "" {
+		if opts.TempFileMaxAge, err = time.ParseDuration(v); err != nil || opts.TempFileMaxAge < 0 {
+			log.Fatalf("Invalid TEMP_FILE_MAX_AGE %q; must be a non-negative duration", v)
+		}
+	}
+
+	// The lock is taken before the temp files of other instances are cleaned up.
+	var lockWait time.Duration
+	switch mode := os.Getenv("LOCK_MODE"); mode {
+	case "", "off":
+	case "exit", "wait":
+		if mode == "wait" {
+			lockWait = defaultLockTimeout
+			if v := os.Getenv("LOCK_TIMEOUT"); v != "" {
+				if lockWait, err = time.ParseDuration(v); err != nil || lockWait <= 0 {
+					log.Fatalf("Invalid LOCK_TIMEOUT %q; must be a positive duration", v)
+				}
+			}
+		}
+		if opts.DryRun {
+			break
+		}
+		if err := os.MkdirAll(opts.DownloadPath, opts.DirMode); err != nil {
+			log.Fatalf("Failed to create download directory %q: %v", opts.DownloadPath, err)
+		}
+		lock, err := acquireLock(opts.DownloadPath, lockWait)
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Warn("File locking is not supported on this platform; running without a lock")
+			break
+		}
 		if err != nil {
-			log.Fatalf("Invalid CHECK_INTERVAL %q; error: %v", checkIntervalStr, err)
+			log.Fatalf("Failed to lock %s: %v", opts.DownloadPath, err)
 		}
+		defer releaseLock(lock)
+		slog.Info("Locked download directory", "event", "lock", "path", lock.Name())
+	default:
+		log.Fatalf("Invalid LOCK_MODE %q; expected off, exit, or wait", mode)
 	}
 
-	client = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:    5,
-			IdleConnTimeout: 30 * time.Second,
-			MaxConnsPerHost: 2,
-		},
+	d, err := downloader.New(opts)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// SIGINT and SIGTERM abort running downloads.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		slog.Info("Received signal, shutting down gracefully", "signal", sig.String())
+		cancel()
+	}()
+
+	if failed := d.SelfTest(ctx); failed > 0 {
+		if failOnStartup {
+			log.Fatalf("Aborting: %d artefacts failed the startup check", failed)
+		}
+		slog.Warn("Startup check failed; continuing", "failed", failed)
 	}
 
 	if runOnce {
-		checkAndDownload(owner, repo, artefacts, downloadPath)
-		log.Println("Run once mode enabled; exiting after initial check.")
+		deadline := time.Now().Add(waitForRelease)
+		for {
+			err := d.Check(ctx)
+			if err == nil && initMode {
+				err = d.Complete()
+			}
+			if err == nil {
+				break
+			}
+			wait := time.Until(deadline)
+			if wait <= 0 || ctx.Err() != nil {
+				slog.Error("Check failed", "error", err)
+				d.Close()
+				logFile.Close()
+				os.Exit(1)
+			}
+			delay := min(waitPollInterval, wait)
+			slog.Info("Check failed; waiting for the release to be published", "event", "wait", "error", err,
+				"retry_in", delay.Round(time.Second), "deadline", deadline.Format(time.RFC3339))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+		}
+		if initMode {
+			slog.Info("All artefacts are present and verified", "event", "init")
+		}
+		slog.Info("Run once mode enabled; exiting after initial check.")
+		d.Close()
 		return
 	}
 
-	log.Println("Starting scheduled download check...")
+	slog.Info("Starting scheduled download check...", "version", version, "commit", commit, "build_date", buildDate)
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// Endpoints configured with the same address share one server.
+	muxes := make(map[string]*http.ServeMux)
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
 
-	ticker := time.NewTicker(checkInterval)
-	defer ticker.Stop()
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	muxFor(metricsAddr).Handle("GET /metrics", d.MetricsHandler())
+	muxFor(metricsAddr).Handle("GET /status", d.StatusHandler())
+	trig := newTrigger()
+	trig.register(muxFor(metricsAddr))
+
+	var probes health
+	if healthAddr := os.Getenv("HEALTH_ADDR"); healthAddr != "" {
+		probes.register(muxFor(healthAddr))
+	}
 
+	for addr, mux := range muxes {
+		srv, err := startServer(addr, mux)
+		if err != nil {
+			log.Fatalf("Failed to start HTTP server on %q: %v", addr, err)
+		}
+		defer stopServer(srv)
+	}
+
+	hup := make(chan os.Signal, 1)
+	notifyReload(hup)
+
+	runCheck := func() {
+		trig.running.Store(true)
+		defer trig.running.Store(false)
+		if err := d.Check(ctx); err != nil {
+			slog.Error("Check failed", "error", err)
+			if opts.RequireAll {
+				probes.ready.Store(false)
+			}
+		} else {
+			probes.ready.Store(true)
+		}
+	}
+
+	// The first check runs right away, so that /readyz doesn't wait a whole
+	// interval. The jitter delays each check without shifting the schedule
+	// itself.
+	next := schedule(time.Now())
+	timer := time.NewTimer(checkJitter.delay())
+	defer timer.Stop()
+
+	// Checks only run from this loop, so scheduled, SIGHUP-triggered, and
+	// HTTP-triggered checks never overlap.
 	for {
 		select {
-		case <-ticker.C:
-			checkAndDownload(owner, repo, artefacts, downloadPath)
-		case sig := <-sigs:
-			log.Printf("Received signal %s, shutting down gracefully", sig)
+		case <-timer.C:
+			runCheck()
+			// Like a ticker, skip runs that were missed while checking.
+			for now := time.Now(); !next.After(now); {
+				next = schedule(next)
+			}
+			delay := checkJitter.delay()
+			timer.Reset(time.Until(next) + delay)
+			slog.Info("Next check scheduled", "at", next.Add(delay).Format(time.RFC3339))
+		case <-hup:
+			slog.Info("Received SIGHUP, checking for new versions now")
+			runCheck()
+		case <-trig.C:
+			slog.Info("Check triggered via HTTP, checking for new versions now")
+			runCheck()
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// artefactsFile is the artefact list read from Options.ArtefactsFile. It is
+// reloaded before every check once the file changed.
+type artefactsFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+	// sources are the sources without their own artefacts.
+	sources []*source
+	// lookup resolves ${VAR} references in the names.
+	lookup func(string) (string, bool)
+}
+
+// load reads the file if it changed since it was last read and hands the
+// list to the sources. Invalid lines are logged and skipped; a file without a
+// single valid line keeps the previous list.
+func (f *artefactsFile) load() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+	artefacts, err := readArtefactsFile(f.path, f.lookup)
+	if err != nil {
+		return err
+	}
+	if len(artefacts) == 0 {
+		return fmt.Errorf("%s lists no valid artefacts", f.path)
+	}
+	if !f.modTime.IsZero() {
+		slog.Info("Reloaded artefacts file", "event", "reload", "path", f.path, "artefacts", len(artefacts))
+	}
+	f.modTime, f.size = fi.ModTime(), fi.Size()
+	for _, src := range f.sources {
+		src.artefacts = artefacts
+	}
+	return nil
+}
+
+// readArtefactsFile parses a file with one artefact per line, either a name
+// or name:dest. Empty lines and comments starting with # are ignored.
+func readArtefactsFile(path string, lookup func(string) (string, bool)) ([]Artefact, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var artefacts []Artefact
+	sc := bufio.NewScanner(file)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, dest, _ := strings.Cut(line, ":")
+		a, err := expandArtefacts([]Artefact{{Name: strings.TrimSpace(name), Dest: strings.TrimSpace(dest)}}, lookup)
+		if err == nil {
+			err = ValidateArtefacts(a)
+		}
+		if err != nil {
+			slog.Warn("Invalid line in artefacts file; skipping", "path", path, "line", n, "error", err)
+			continue
+		}
+		artefacts = append(artefacts, a...)
+	}
+	return artefacts, sc.Err()
+}
@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"log/slog"
+)
+
+// maxBackoffCycles caps the scheduled checks a failing artefact sits out.
+const maxBackoffCycles = 32
+
+// backoffState tracks the consecutive failed checks of an artefact.
+type backoffState struct {
+	failures int
+	// skip is the number of upcoming checks the artefact sits out.
+	skip int
+}
+
+// backingOff reports whether the artefact key sits out the current check,
+// counting the check as sat out if so.
+func (s *source) backingOff(key string) bool {
+	b := s.backoff[key]
+	if b == nil || b.skip == 0 {
+		return false
+	}
+	b.skip--
+	return true
+}
+
+// failed records a failed check of the artefact key. After the first failure,
+// which is often transient, the artefact sits out 1, 3, 7, ... checks, up to
+// maxBackoffCycles.
+func (s *source) failed(key string) {
+	b := s.backoff[key]
+	if b == nil {
+		b = &backoffState{}
+		s.backoff[key] = b
+	}
+	b.failures++
+	b.skip = min(1<<min(b.failures-1, 30)-1, maxBackoffCycles)
+	if b.failures == 2 {
+		slog.Warn("Artefact keeps failing; backing off", "event", "backoff", "artefact", s.label(key), "failures", b.failures)
+	}
+	if b.skip > 0 {
+		slog.Info("Skipping the next checks of failing artefact", "event", "backoff", "artefact", s.label(key),
+			"failures", b.failures, "skipped_checks", b.skip)
+	}
+}
+
+// succeeded resets the failures of the artefact key.
+func (s *source) succeeded(key string) {
+	if b := s.backoff[key]; b != nil && b.failures >= 2 {
+		slog.Info("Artefact recovered; leaving backoff", "event", "backoff", "artefact", s.label(key), "failures", b.failures)
+	}
+	delete(s.backoff, key)
+}
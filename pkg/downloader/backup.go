@@ -0,0 +1,27 @@
+package downloader
+
+import (
+	"os"
+)
+
+// backupSuffix is appended to the name of the backup of an artefact.
+const backupSuffix = ".bak"
+
+// backupArtefact keeps the current version of the artefact at localFilePath
+// as its single backup, replacing an older backup. The artefact is hard
+// linked where possible so that it stays in place until the new version is
+// renamed over it.
+func backupArtefact(localFilePath string) error {
+	if _, err := os.Stat(localFilePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	backup := localFilePath + backupSuffix
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(localFilePath, backup); err == nil {
+		return nil
+	}
+	return os.Rename(localFilePath, backup)
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// storeInCAS moves a download into the content-addressed cache below dir,
+// where it is stored as sha256/<first two hex digits>/<digest>, and replaces
+// path with a hard link to the cached file. If the cache already holds the
+// same content, the download is discarded instead. Where hard links aren't
+// possible, such as across filesystems, path becomes a symlink. It reports
+// whether the content was already cached. Only content that is newly cached
+// is passed to prepare first, to set its mode and mod time, as a cached file
+// is shared by every path linked to it. Directories of the cache are created
+// with dirMode.
+func storeInCAS(dir, tmpFile, path string, dirMode fs.FileMode, prepare func(name string) error) (bool, error) {
+	digest, err := hashFile(tmpFile, sha256.New())
+	if err != nil {
+		return false, err
+	}
+	sum := hex.EncodeToString(digest)
+	cached := filepath.Join(dir, "sha256", sum[:2], sum)
+
+	_, err = os.Stat(cached)
+	hit := err == nil
+	if hit {
+		os.Remove(tmpFile)
+	} else {
+		if err := prepare(tmpFile); err != nil {
+			return false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(cached), dirMode); err != nil {
+			return false, err
+		}
+		// Instances sharing the cache may store the same content at once;
+		// the renames replace one identical file with another.
+		if err := moveFile(tmpFile, cached); err != nil {
+			return false, err
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), tempPrefix+filepath.Base(path)+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	if err := os.Link(cached, tmp); err != nil {
+		abs, err := filepath.Abs(cached)
+		if err != nil {
+			return hit, err
+		}
+		if err := os.Symlink(abs, tmp); err != nil {
+			return hit, err
+		}
+	}
+	if err := rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return hit, err
+	}
+	return hit, nil
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// hashForDigest picks the hash algorithm based on the length of a hex digest.
+func hashForDigest(digest string) (string, error) {
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", fmt.Errorf("digest %q is not hex encoded", digest)
+	}
+	switch len(digest) {
+	case sha256.Size * 2:
+		return "sha256", nil
+	case sha512.Size * 2:
+		return "sha512", nil
+	default:
+		return "", fmt.Errorf("digest %q has unsupported length %d", digest, len(digest))
+	}
+}
+
+// fetchCompanion downloads a small companion file of an artefact, such as a
+// checksum or signature file.
+func fetchCompanion(ctx context.Context, c *retryClient, logger *slog.Logger, p provider, url string) ([]byte, error) {
+	req, err := p.newRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for companion file %s: %v", url, err)
+	}
+	resp, err := c.do(logger, req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading companion file %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if err := p.checkAuth(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download companion file %s: HTTP status %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("error reading companion file %s: %v", url, err)
+	}
+	return body, nil
+}
+
+// fetchCompanionChecksum downloads a companion checksum file and returns the
+// digest it contains. Both the bare digest and the sha256sum output format
+// ("<digest>  <filename>") are accepted.
+func fetchCompanionChecksum(ctx context.Context, c *retryClient, logger *slog.Logger, p provider, url string) (string, error) {
+	body, err := fetchCompanion(ctx, c, logger, p, url)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file %s is empty", url)
+	}
+	digest := strings.ToLower(fields[0])
+	if _, err := hashForDigest(digest); err != nil {
+		return "", fmt.Errorf("invalid checksum file %s: %v", url, err)
+	}
+	return digest, nil
+}
+
+// manifestLookup returns the digest of an asset from the checksum manifest of
+// its release.
+type manifestLookup func(artefact string) (string, error)
+
+// newManifestLookup returns a lookup that downloads the checksum manifest name
+// from the URL url returns for it on first use. If gpg is set, the manifest is
+// only trusted once its detached signature is verified. It is safe for
+// concurrent use.
+func newManifestLookup(ctx context.Context, c *retryClient, logger *slog.Logger, p provider, name string, url func(name string) (string, error), gpg *gpgVerifier) manifestLookup {
+	load := sync.OnceValues(func() (map[string]string, error) {
+		u, err := url(name)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fetchCompanion(ctx, c, logger, p, u)
+		if err != nil {
+			return nil, err
+		}
+		if gpg != nil {
+			sigURL, err := url(name + gpgSignatureSuffix)
+			if err != nil {
+				return nil, err
+			}
+			sig, err := fetchCompanion(ctx, c, logger, p, sigURL)
+			if err != nil {
+				return nil, err
+			}
+			if err := gpg.verify(body, sig); err != nil {
+				return nil, fmt.Errorf("signature verification failed: %v", err)
+			}
+			logger.Info("Verified signature of checksum manifest", "scheme", "gpg")
+		}
+		return parseManifest(string(body))
+	})
+	return func(artefact string) (string, error) {
+		digests, err := load()
+		if err != nil {
+			return "", fmt.Errorf("error loading checksum manifest %s: %v", name, err)
+		}
+		digest, ok := digests[artefact]
+		if !ok {
+			return "", fmt.Errorf("%s is not listed in checksum manifest %s", artefact, name)
+		}
+		return digest, nil
+	}
+}
+
+// parseManifest parses a checksum manifest in the sha256sum output format,
+// with one "<digest>  <filename>" line per asset.
+func parseManifest(s string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, filename, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid manifest line %d; expected <digest>  <filename>", i+1)
+		}
+		digest = strings.ToLower(digest)
+		if _, err := hashForDigest(digest); err != nil {
+			return nil, fmt.Errorf("invalid manifest line %d: %v", i+1, err)
+		}
+		// A "*" marks files hashed in binary mode.
+		filename = strings.TrimPrefix(strings.TrimLeft(filename, " *"), "./")
+		digests[filename] = digest
+	}
+	return digests, nil
+}
+
+// verifyChecksum hashes the file at path and compares it against the
+// expected digest. It returns the name of the algorithm that was used.
+func verifyChecksum(path, expected string) (string, error) {
+	algorithm, err := hashForDigest(expected)
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	if algorithm == "sha512" {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return algorithm, fmt.Errorf("error opening %s for checksum verification: %v", path, err)
+	}
+	defer f.Close()
+
+	buf := buffers.Get().([]byte)
+	defer buffers.Put(buf)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil {
+		return algorithm, fmt.Errorf("error hashing %s: %v", path, err)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return algorithm, &checksumMismatchError{fmt.Errorf("%s checksum mismatch: expected %s, got %s", algorithm, expected, actual), actual}
+	}
+	return algorithm, nil
+}
+
+// checksumMismatchError is a download whose digest differs from the expected
+// one, which is downloaded again up to CHECKSUM_RETRIES times.
+type checksumMismatchError struct {
+	err    error
+	actual string
+}
+
+func (e *checksumMismatchError) Error() string { return e.err.Error() }
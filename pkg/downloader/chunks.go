@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// minChunkSize is the smallest byte range a download is split into.
+const minChunkSize = 1 << 20
+
+// MaxParallelChunks is the highest Options.ParallelChunks.
+const MaxParallelChunks = 16
+
+// chunkCount returns the number of byte ranges to download the artefact of
+// resp in, which is 1 if the server doesn't support range requests or the
+// artefact is too small to be worth splitting.
+func chunkCount(resp *http.Response, chunks int) int {
+	if chunks <= 1 || resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 ||
+		!strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return 1
+	}
+	return max(1, int(min(int64(chunks), resp.ContentLength/minChunkSize)))
+}
+
+// errRangeIgnored reports a range request answered with the whole artefact.
+var errRangeIgnored = errors.New("server ignored the range request")
+
+// downloadChunks saves the artefact of resp to out in n byte ranges that are
+// downloaded in parallel. The first range is read from in, the body of resp,
+// and the others are requested with copies of req, their bodies wrapped by
+// wrap. If the server ignores the range requests after all, the rest of the
+// body is copied instead. It returns the number of bytes written.
+func downloadChunks(ctx context.Context, cfg *config, logger *slog.Logger, req *http.Request, resp *http.Response,
+	in io.Reader, wrap func(io.Reader) io.Reader, out *os.File, n int) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	size := resp.ContentLength
+	chunk := size / int64(n)
+	// If-Range makes sure all ranges come from the same version.
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	logger.Info("Downloading artefact in parallel byte ranges", "chunks", n, "chunk_size", chunk)
+
+	fetch := func(start, end int64) error {
+		r := req.Clone(ctx)
+		r.Header.Del("If-None-Match")
+		r.Header.Del("If-Modified-Since")
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if validator != "" {
+			r.Header.Set("If-Range", validator)
+		}
+		cr, err := cfg.client.do(logger, r)
+		if err != nil {
+			return err
+		}
+		defer cr.Body.Close()
+		if cr.StatusCode == http.StatusOK {
+			return errRangeIgnored
+		}
+		if cr.StatusCode != http.StatusPartialContent {
+			return fmt.Errorf("HTTP status %s", cr.Status)
+		}
+		if total, err := checkContentRange(cr, start); err != nil {
+			return err
+		} else if total != size {
+			return fmt.Errorf("range of %d bytes in total, expected %d", total, size)
+		}
+		body := newStallReader(cr.Body, cfg.httpTimeout, cancel)
+		defer body.Stop()
+		return copyRange(cfg.buffers, out, wrap(body), start, end-start+1)
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 1; i < n; i++ {
+		start, end := int64(i)*chunk, int64(i+1)*chunk-1
+		if i == n-1 {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = fetch(start, end); errs[i] != nil && !errors.Is(errs[i], errRangeIgnored) {
+				cancel()
+			}
+		}()
+	}
+	errs[0] = copyRange(cfg.buffers, out, in, 0, chunk)
+	wg.Wait()
+
+	if errs[0] != nil {
+		return 0, errs[0]
+	}
+	ignored := false
+	for i, err := range errs {
+		if errors.Is(err, errRangeIgnored) {
+			ignored = true
+		} else if err != nil {
+			return 0, fmt.Errorf("error downloading byte range %d of %d: %v", i+1, n, err)
+		}
+	}
+	if ignored {
+		logger.Warn("Server ignored range requests; downloading the rest in one stream")
+		if err := copyRange(cfg.buffers, out, in, chunk, size-chunk); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// copyRange copies n bytes from r to out at offset start, with a buffer of
+// pool.
+func copyRange(pool *sync.Pool, out *os.File, r io.Reader, start, n int64) error {
+	buf := pool.Get().([]byte)
+	defer pool.Put(buf)
+	written, err := io.CopyBuffer(io.NewOffsetWriter(out, start), io.LimitReader(r, n), buf)
+	if err == nil && written != n {
+		err = fmt.Errorf("expected %d bytes at offset %d, got %d", n, start, written)
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+package downloader
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config holds the settings and the state of a Downloader.
+type config struct {
+	sources           []*source
+	downloadPath      string
+	checksums         map[string]string
+	checksumCompanion bool
+	checksumManifest  string
+	checksumRetries   int
+	manifestSignature *gpgVerifier
+	signature         *signatureVerifier
+	concurrency       int
+	client            *retryClient
+	dryRun            bool
+	failureBackoff    bool
+	progress          bool
+	requireAll        bool
+	extract           bool
+	keepArchive       bool
+	executables       []string
+	exclude           []string
+	artefactsFile     *artefactsFile
+	postDownloadHook  string
+	notifiers         []notifier
+	rejectHTML        bool
+	resume            bool
+	contentFreshness  bool
+	keepBackup        bool
+	state             *stateStore
+	manifest          *downloadManifest
+	unverified        *unverifiedSet
+	storage           storage
+	casDir            string
+	verifyOnStart     bool
+	disabled          *disabledList
+	parallelChunks    int
+	mtimeSource       string
+	keepVersions      int
+	symlinks          map[string]string
+	minReleaseAge     time.Duration
+	maxModTimeSkew    time.Duration
+	redownloadOnSkew  bool
+	bandwidth         *rateLimiter
+	diskSpaceMargin   int64
+	maxSize           int64
+	httpTimeout       time.Duration
+	fileMode          fs.FileMode
+	dirMode           fs.FileMode
+	urlTemplate       *template.Template
+	lookup            func(string) (string, bool)
+	metrics           *metrics
+	status            *status
+	events            *eventSocket
+	tracer            *otlpTracer
+	// buffers holds the buffers downloads are copied to disk with.
+	buffers *sync.Pool
+}
+
+// source is a repository whose artefacts are downloaded into their own
+// directory below the download path.
+type source struct {
+	owner      string
+	repo       string
+	releaseTag string
+	provider   provider
+	// dir is relative to the download path and empty for a single source.
+	dir          string
+	downloadPath string
+	artefacts    []Artefact
+	// lastChecked holds the last successful check of artefacts with their own
+	// interval.
+	lastChecked map[string]time.Time
+	// backoff holds the artefacts that failed their last check.
+	backoff map[string]*backoffState
+	// listed is set if the artefacts are the global list rather than the
+	// source's own, so that they follow ARTEFACTS_FILE.
+	listed bool
+}
+
+// label names an artefact of the source uniquely across all sources.
+func (s *source) label(artefact string) string {
+	return path.Join(s.dir, artefact)
+}
+
+// localPath returns the path an artefact of the source is saved to.
+func (s *source) localPath(a Artefact) string {
+	return filepath.Join(s.downloadPath, filepath.FromSlash(cmp.Or(a.Dest, a.Name)))
+}
+
+// dirs returns the directories the artefacts of the source are saved to.
+func (s *source) dirs() []string {
+	dirs := []string{s.downloadPath}
+	for _, a := range s.artefacts {
+		if dir := filepath.Dir(s.localPath(a)); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// due reports whether an artefact is checked at now. Artefacts without their
+// own interval follow the global schedule and are always due.
+func (s *source) due(a Artefact, now time.Time) bool {
+	last, ok := s.lastChecked[a.Name]
+	// Checks start slightly early or late, so allow for some slack.
+	return a.Interval == 0 || !ok || now.Sub(last) >= a.Interval-a.Interval/10
+}
+
+// tagFor returns the release tag an artefact is downloaded from; empty means
+// the latest release.
+func (s *source) tagFor(a Artefact) string {
+	if a.Tag != "" {
+		return a.Tag
+	}
+	return s.releaseTag
+}
+
+// Artefact is a configured artefact together with the options that
+// override the global settings for it.
+type Artefact struct {
+	Name       string        `yaml:"name"`
+	Dest       string        `yaml:"dest"`
+	Tag        string        `yaml:"tag"`
+	Checksum   string        `yaml:"checksum"`
+	Executable *bool         `yaml:"executable"`
+	Extract    *bool         `yaml:"extract"`
+	Interval   time.Duration `yaml:"interval"`
+	Symlink    string        `yaml:"symlink"`
+	// ContentType lists the accepted media types, which may contain wildcards
+	// such as "application/*".
+	ContentType []string `yaml:"content-type"`
+	// Mirrors are URL templates tried in order if the release is unavailable.
+	Mirrors []string `yaml:"mirrors"`
+	// VersionURL is a URL template of a small file with the current version
+	// of the artefact, which is only checked once the version changed.
+	VersionURL string `yaml:"version-url"`
+	// Disabled artefacts are skipped by every check, leaving their file as is.
+	Disabled bool `yaml:"disabled"`
+	// pattern is the glob pattern the name was expanded from, if any.
+	pattern string
+}
+
+// UnmarshalYAML accepts either a plain artefact name or a mapping with options.
+func (a *Artefact) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&a.Name)
+	}
+	type plain Artefact
+	return value.Decode((*plain)(a))
+}
+
+// ValidateArtefacts reports the first invalid option of artefacts, such as a
+// destination outside the download path or a malformed checksum.
+func ValidateArtefacts(artefacts []Artefact) error {
+	for i, a := range artefacts {
+		if a.Name == "" {
+			return fmt.Errorf("artefact %d has no name", i+1)
+		}
+		if a.Dest != "" && (isPattern(a.Name) || !filepath.IsLocal(filepath.FromSlash(a.Dest))) {
+			return fmt.Errorf("invalid destination %q of %s: must be a relative path and can't be used with patterns", a.Dest, a.Name)
+		}
+		if a.Interval < 0 {
+			return fmt.Errorf("invalid interval %s of %s: must not be negative", a.Interval, a.Name)
+		}
+		for _, t := range a.ContentType {
+			if _, err := path.Match(t, ""); err != nil || !strings.Contains(t, "/") {
+				return fmt.Errorf("invalid content type %q of %s", t, a.Name)
+			}
+		}
+		for _, m := range a.Mirrors {
+			if _, err := template.New("mirror").Parse(m); err != nil {
+				return fmt.Errorf("invalid mirror %q of %s: %v", m, a.Name, err)
+			}
+		}
+		if a.VersionURL != "" {
+			if _, err := template.New("version").Parse(a.VersionURL); err != nil {
+				return fmt.Errorf("invalid version URL %q of %s: %v", a.VersionURL, a.Name, err)
+			}
+		}
+		if a.Checksum != "" {
+			if _, err := hashForDigest(a.Checksum); err != nil {
+				return fmt.Errorf("invalid checksum for %s: %v", a.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandArtefacts returns a copy of artefacts with ${VAR} references in their
+// names and destinations replaced by the variables lookup resolves. ${OS} and
+// ${ARCH} are the platform the downloader runs on, in the notation of GOOS and
+// GOARCH.
+func expandArtefacts(artefacts []Artefact, lookup func(string) (string, bool)) ([]Artefact, error) {
+	var undefined []string
+	mapping := func(name string) string {
+		switch name {
+		case "OS":
+			return runtime.GOOS
+		case "ARCH":
+			return runtime.GOARCH
+		}
+		if lookup != nil {
+			if v, ok := lookup(name); ok {
+				return v
+			}
+		}
+		if !slices.Contains(undefined, name) {
+			undefined = append(undefined, name)
+		}
+		return ""
+	}
+
+	expanded := make([]Artefact, len(artefacts))
+	for i, a := range artefacts {
+		a.Name = os.Expand(a.Name, mapping)
+		// Destinations are kept with forward slashes, like the keys of
+		// the state file, and converted when the path is built.
+		a.Dest = filepath.ToSlash(os.Expand(a.Dest, mapping))
+		expanded[i] = a
+	}
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined variables in artefact names: %s", strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
+
+// checksumFor returns the expected checksum of an artefact, if one is configured.
+func (cfg *config) checksumFor(a Artefact) string {
+	if a.Checksum != "" {
+		return a.Checksum
+	}
+	return cfg.checksums[a.Name]
+}
+
+// checkSize fails if size exceeds the maximum artefact size. Unknown sizes are
+// negative and pass.
+func (cfg *config) checkSize(artefact string, size int64) error {
+	if cfg.maxSize > 0 && size > cfg.maxSize {
+		return fmt.Errorf("refusing to download %s: size of %d bytes exceeds the maximum of %d bytes", artefact, size, cfg.maxSize)
+	}
+	return nil
+}
+
+// executable reports whether an artefact is made executable after download.
+func (cfg *config) executable(a Artefact) bool {
+	if a.Executable != nil {
+		return *a.Executable
+	}
+	return matchesAny(cfg.executables, a.Name)
+}
+
+// extractFor reports whether an artefact is extracted after download.
+func (cfg *config) extractFor(a Artefact) bool {
+	if a.Extract != nil {
+		return *a.Extract
+	}
+	return cfg.extract
+}
+
+// isCompanion reports whether an asset is the checksum manifest, its GPG
+// signature, or a checksum or signature file of another asset, which
+// patterns don't expand to since they can't be verified themselves.
+func (cfg *config) isCompanion(name string) bool {
+	switch {
+	case cfg.checksumManifest != "" && (name == cfg.checksumManifest || name == cfg.checksumManifest+gpgSignatureSuffix):
+		return true
+	case cfg.checksumCompanion && strings.HasSuffix(name, ".sha256"):
+		return true
+	case cfg.signature != nil && strings.HasSuffix(name, cfg.signature.suffix):
+		return true
+	}
+	return false
+}
+
+// matchesAny reports whether name equals or matches one of the glob patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || pattern == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDestSeparators(t *testing.T) {
+	tests := []struct {
+		name string
+		dest string
+		// windows marks destinations whose separators are only recognized
+		// on Windows.
+		windows bool
+	}{
+		{name: "forward slashes", dest: "bin/tool"},
+		{name: "backslashes", dest: `bin\tool`, windows: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.windows && runtime.GOOS != "windows" {
+				t.Skip("backslashes are part of file names on this platform")
+			}
+			artefacts, err := expandArtefacts([]Artefact{{Name: "tool-linux-amd64", Dest: tt.dest}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ValidateArtefacts(artefacts); err != nil {
+				t.Fatal(err)
+			}
+			a := artefacts[0]
+			if a.Dest != "bin/tool" {
+				t.Errorf("Dest = %q, want %q", a.Dest, "bin/tool")
+			}
+			dir := t.TempDir()
+			src := &source{dir: "repo", downloadPath: filepath.Join(dir, "repo")}
+			if got, want := src.localPath(a), filepath.Join(dir, "repo", "bin", "tool"); got != want {
+				t.Errorf("localPath = %q, want %q", got, want)
+			}
+			if got, want := src.stateKey(a), "repo/bin/tool"; got != want {
+				t.Errorf("stateKey = %q, want %q", got, want)
+			}
+		})
+	}
+}
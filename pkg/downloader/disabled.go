@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// disabledList holds the names and glob patterns of the disabled artefacts.
+// Those of a file are reloaded before every check once it changed.
+type disabledList struct {
+	path     string
+	modTime  time.Time
+	size     int64
+	patterns []string
+}
+
+// newDisabledList returns the list of patterns, or of the patterns read from
+// file if it is set.
+func newDisabledList(patterns []string, file string) (*disabledList, error) {
+	if file != "" {
+		d := &disabledList{path: file}
+		return d, d.load()
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return &disabledList{patterns: patterns}, nil
+}
+
+// load reads the file of the list if it changed since it was last read. An
+// empty file enables all artefacts again; invalid patterns are logged and
+// skipped.
+func (d *disabledList) load() error {
+	if d == nil || d.path == "" {
+		return nil
+	}
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(d.modTime) && fi.Size() == d.size {
+		return nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	var patterns []string
+	for n, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, pattern := range strings.Split(line, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				slog.Warn("Invalid pattern in disabled artefacts file; skipping", "path", d.path, "line", n+1, "pattern", pattern)
+				continue
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+	if !d.modTime.IsZero() {
+		slog.Info("Reloaded disabled artefacts file", "event", "reload", "path", d.path, "disabled", patterns)
+	}
+	d.modTime, d.size, d.patterns = fi.ModTime(), fi.Size(), patterns
+	return nil
+}
+
+// has reports whether an artefact of src is disabled, by its name or its
+// label. It is false for a nil list.
+func (d *disabledList) has(src *source, name string) bool {
+	return d != nil && (matchesAny(d.patterns, name) || matchesAny(d.patterns, src.label(name)))
+}
@@ -0,0 +1,23 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+)
+
+// checkDiskSpace fails if the filesystem of dir doesn't have room for size
+// more bytes plus margin. Platforms that can't report free space pass.
+func checkDiskSpace(dir string, size, margin int64) error {
+	free, err := freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking free disk space of %s: %v", dir, err)
+	}
+	if free < size+margin {
+		return fmt.Errorf("insufficient disk space in %s: %d bytes needed (including a margin of %d), %d available",
+			dir, size+margin, margin, free)
+	}
+	return nil
+}
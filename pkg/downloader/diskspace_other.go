@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package downloader
+
+import "errors"
+
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
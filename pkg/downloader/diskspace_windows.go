@@ -0,0 +1,19 @@
+//go:build windows
+
+package downloader
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the number of bytes available to the current user on the
+// volume of dir.
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(free), nil
+}
@@ -0,0 +1,983 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// defaultUserAgent is the User-Agent of DefaultOptions.
+const defaultUserAgent = "artifact-downloader"
+
+// defaultCopyBufferSize is the CopyBufferSize of DefaultOptions and the size
+// of the buffers used to hash, move, and extract files.
+const defaultCopyBufferSize = 32 * 1024
+
+// buffers holds the copy buffers of the file helpers, so that concurrent
+// downloads don't share one. Downloads use the buffers of their config.
+var buffers = sync.Pool{New: func() any { return make([]byte, defaultCopyBufferSize) }}
+
+// newBufferPool returns a pool of copy buffers of the given size.
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{New: func() any { return make([]byte, size) }}
+}
+
+// copyBuffered copies src to dst with a buffer of pool. Unlike io.CopyBuffer
+// with a file as dst, it always uses the buffer, so that its size takes
+// effect.
+func copyBuffered(dst io.Writer, src io.Reader, pool *sync.Pool) (int64, error) {
+	buf := pool.Get().([]byte)
+	defer pool.Put(buf)
+	// Hiding ReadFrom and WriteTo keeps io.CopyBuffer from bypassing buf.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
+// resolver returns the download URL of the asset with the given name in the
+// release with the given tag.
+type resolver func(tag, name string) (string, error)
+
+// downloadFrom fetches an artefact from url if the remote copy is newer than
+// the local one. It reports whether the artefact changed, or in dry-run mode
+// whether it would have been downloaded.
+func downloadFrom(ctx context.Context, cfg *config, src *source, resolve resolver, lookup manifestLookup, spec Artefact, url string) (bool, error) {
+	artefact, tag := spec.Name, src.tagFor(spec)
+	localFilePath := src.localPath(spec)
+	logger := slog.With("artefact", src.label(artefact))
+	sp := spanFromContext(ctx)
+	sp.set("url.full", url)
+
+	needDownload := true
+	etag := ""
+	var modifiedSince time.Time
+	fi, statErr := cfg.storage.stat(ctx, localFilePath)
+	if statErr != nil && !os.IsNotExist(statErr) {
+		return false, fmt.Errorf("error checking stored %s: %v", artefact, statErr)
+	}
+	var localModTime time.Time
+	// storedModTime is the Last-Modified time of the previous download, for
+	// servers that omit it from 304 responses.
+	var storedModTime string
+	// stored is the state of the stored artefact, if known.
+	var stored *stateEntry
+	if statErr == nil {
+		etag = fi.ETag
+		localModTime = fi.ModTime
+		// The state file survives losing the sidecar files and mod times.
+		if state, ok := cfg.state.lookup(src.stateKey(spec), fi.Size); ok {
+			stored = &state
+			etag = cmp.Or(etag, state.ETag)
+			if !state.LastModified.IsZero() {
+				localModTime = state.LastModified
+				storedModTime = state.LastModified.UTC().Format(http.TimeFormat)
+			}
+		}
+	}
+	// forced is set if the existing file failed VERIFY_ON_START.
+	forced := statErr == nil && cfg.unverified.has(src.stateKey(spec))
+	if forced {
+		logger.Info("Downloading artefact again after it failed verification", "event", "verify")
+		etag = ""
+	} else if statErr == nil && tag != "" {
+		// Assets of a pinned release never change, so there is nothing to compare.
+		logger.Debug("Artefact exists and release is pinned; skipping", "event", "skip")
+		needDownload = false
+	} else if etag != "" && !cfg.dryRun {
+		// The conditional GET below tells us whether the artefact changed.
+		logger.Debug("Checking for changes using stored ETag", "etag", etag)
+	} else if statErr == nil && !cfg.dryRun {
+		// A conditional GET saves the separate HEAD request.
+		modifiedSince = localModTime
+		logger.Debug("Checking for changes using If-Modified-Since", "local_mod_time", modifiedSince)
+	} else if statErr == nil {
+		req, err := src.provider.newRequest(ctx, "HEAD", url)
+		if err != nil {
+			return false, fmt.Errorf("error creating HEAD request for %s: %v", url, err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		logger.Debug("Checking for changes using HEAD", "url", url, "headers", redactHeaders(req.Header))
+		resp, err := cfg.client.do(logger, req)
+		if err != nil {
+			return false, unavailable(ctx, fmt.Errorf("error performing HEAD request for %s: %v", artefact, err))
+		}
+		resp.Body.Close()
+		sp.set("http.response.status_code", resp.StatusCode)
+		if err := src.provider.checkAuth(resp); err != nil {
+			return false, err
+		}
+		if err := cfg.checkSize(artefact, resp.ContentLength); err != nil {
+			return false, err
+		}
+
+		if resp.StatusCode == http.StatusNotModified {
+			logger.Debug("No new version available", "event", "skip", "status", resp.StatusCode, "etag", etag)
+			needDownload = false
+		} else if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			remoteModTime, err := time.Parse(http.TimeFormat, lastModified)
+			if err != nil {
+				logger.Warn("Error parsing Last-Modified header", "url", url, "error", err)
+			} else if !remoteModTime.After(localModTime) && !cfg.skewed(logger, fi.ModTime, lastModified) {
+				logger.Debug("No new version available", "event", "skip",
+					"remote_mod_time", remoteModTime, "local_mod_time", localModTime)
+				needDownload = false
+			}
+		} else {
+			logger.Info("No Last-Modified header; proceeding to download", "url", url)
+		}
+	}
+
+	if needDownload && cfg.dryRun {
+		logger.Info("Dry run: artefact would be downloaded", "event", "dry_run", "url", url)
+		return true, nil
+	}
+
+	if needDownload {
+		req, err := src.provider.newRequest(ctx, "GET", url)
+		if err != nil {
+			return false, fmt.Errorf("error creating GET request for %s: %v", url, err)
+		}
+		logger.Debug("Requesting artefact", "url", url, "headers", redactHeaders(req.Header))
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if !modifiedSince.IsZero() {
+			req.Header.Set("If-Modified-Since", modifiedSince.UTC().Format(http.TimeFormat))
+		}
+		var partial string
+		var offset int64
+		if cfg.resume {
+			partial = partialPath(localFilePath)
+			if offset = prepareResume(req, partial); offset > 0 {
+				logger.Info("Resuming interrupted download", "offset", offset)
+			}
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		resp, err := cfg.client.do(logger, req.WithContext(ctx))
+		if err != nil {
+			return false, unavailable(ctx, fmt.Errorf("error downloading %s: %v", artefact, err))
+		}
+		defer resp.Body.Close()
+		sp.set("http.response.status_code", resp.StatusCode)
+
+		if err := src.provider.checkAuth(resp); err != nil {
+			return false, err
+		}
+		if resp.StatusCode == http.StatusNotModified && statErr == nil && cfg.skewed(logger, fi.ModTime, cmp.Or(resp.Header.Get("Last-Modified"), storedModTime)) {
+			// The local metadata can't be trusted; fetch the artefact unconditionally.
+			resp.Body.Close()
+			req.Header.Del("If-None-Match")
+			req.Header.Del("If-Modified-Since")
+			modifiedSince = time.Time{}
+			if resp, err = cfg.client.do(logger, req.WithContext(ctx)); err != nil {
+				return false, unavailable(ctx, fmt.Errorf("error downloading %s: %v", artefact, err))
+			}
+			defer resp.Body.Close()
+			sp.set("http.response.status_code", resp.StatusCode)
+			if err := src.provider.checkAuth(resp); err != nil {
+				return false, err
+			}
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			logger.Debug("No new version available", "event", "skip", "status", resp.StatusCode, "etag", etag)
+			return false, nil
+		}
+		if !modifiedSince.IsZero() && resp.StatusCode == http.StatusOK && !remoteNewer(resp, modifiedSince) &&
+			!cfg.skewed(logger, fi.ModTime, resp.Header.Get("Last-Modified")) {
+			// The server ignored If-Modified-Since; don't read the body.
+			logger.Debug("No new version available", "event", "skip",
+				"remote_mod_time", resp.Header.Get("Last-Modified"), "local_mod_time", modifiedSince)
+			return false, nil
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+			removePartial(partial)
+			return false, fmt.Errorf("server refused to resume %s at byte %d; the next attempt starts over", artefact, offset)
+		}
+		resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent
+		if resp.StatusCode != http.StatusOK && !resumed {
+			return false, fmt.Errorf("failed to download %s: HTTP status %s", artefact, resp.Status)
+		}
+		logger.Info("Downloading artefact", "event", "download_start", "url", url, "status", resp.StatusCode)
+		cfg.events.emit(event{Event: "download_start", Artefact: src.label(artefact), URL: url, Status: resp.StatusCode})
+		total := int64(-1)
+		if resumed {
+			if total, err = checkContentRange(resp, offset); err != nil {
+				removePartial(partial)
+				return false, fmt.Errorf("error resuming %s: %v", artefact, err)
+			}
+		} else if offset > 0 {
+			logger.Info("Server sent the whole artefact; restarting download")
+		}
+		if len(spec.ContentType) > 0 {
+			if err := checkContentType(resp.Header.Get("Content-Type"), spec.ContentType); err != nil {
+				return false, fmt.Errorf("rejected %s: %v", artefact, err)
+			}
+		}
+		size := resp.ContentLength
+		if resumed {
+			size = total
+		}
+		if err := cfg.checkSize(artefact, size); err != nil {
+			if resumed {
+				removePartial(partial)
+			}
+			return false, err
+		}
+		if resp.ContentLength > 0 {
+			if err := checkDiskSpace(src.downloadPath, resp.ContentLength, cfg.diskSpaceMargin); err != nil {
+				return false, err
+			}
+		}
+
+		body := newStallReader(resp.Body, cfg.httpTimeout, cancel)
+		defer body.Stop()
+		var in io.Reader = body
+		if cfg.bandwidth != nil {
+			in = cfg.bandwidth.reader(in)
+		}
+		var prog *progressReader
+		if cfg.progress {
+			start, size := int64(0), resp.ContentLength
+			if resumed {
+				start, size = offset, total
+			}
+			prog = newProgressReader(in, logger, start, size)
+			in = prog
+		}
+		if cfg.maxSize > 0 {
+			// Without a Content-Length the cap is enforced while copying; one
+			// byte more than allowed tells that the artefact is too large.
+			in = io.LimitReader(in, cfg.maxSize-offset+1)
+		}
+		if cfg.rejectHTML && !resumed {
+			br := bufio.NewReader(in)
+			head, _ := br.Peek(512)
+			if looksLikeHTML(resp.Header.Get("Content-Type"), head) {
+				return false, fmt.Errorf("rejected %s: server returned an HTML page instead of the artefact", artefact)
+			}
+			in = br
+		}
+
+		if err := os.MkdirAll(filepath.Dir(localFilePath), cfg.dirMode); err != nil {
+			return false, fmt.Errorf("error creating directory for %s: %v", artefact, err)
+		}
+		var out *os.File
+		if partial != "" {
+			out, err = openPartial(partial, resp, resumed)
+		} else {
+			out, err = createTemp(filepath.Dir(localFilePath), filepath.Base(localFilePath), cfg.fileMode)
+		}
+		if err != nil {
+			return false, fmt.Errorf("error creating temp file for %s: %v", artefact, err)
+		}
+		tmpFile := out.Name()
+		// discard removes the temp file of a failed download unless the
+		// download can be resumed from it.
+		discard := func() {
+			if partial == "" {
+				os.Remove(tmpFile)
+			} else if !canResume(partial) {
+				removePartial(partial)
+			}
+		}
+
+		var written int64
+		if chunks := chunkCount(resp, cfg.parallelChunks); chunks > 1 && partial == "" {
+			// The other byte ranges are throttled and counted like the body.
+			wrap := func(r io.Reader) io.Reader {
+				if cfg.bandwidth != nil {
+					r = cfg.bandwidth.reader(r)
+				}
+				if prog != nil {
+					r = prog.with(r)
+				}
+				return r
+			}
+			written, err = downloadChunks(ctx, cfg, logger, req, resp, in, wrap, out, chunks)
+		} else {
+			written, err = copyBuffered(out, in, cfg.buffers)
+		}
+		sp.set("artefact.bytes", written)
+		if err != nil {
+			out.Close()
+			discard()
+			return false, fmt.Errorf("error saving file %s: %v", tmpFile, err)
+		}
+		out.Close()
+
+		if cfg.maxSize > 0 && offset+written > cfg.maxSize {
+			if partial != "" {
+				removePartial(partial)
+			} else {
+				os.Remove(tmpFile)
+			}
+			return false, fmt.Errorf("aborted download of %s: exceeded the maximum of %d bytes", artefact, cfg.maxSize)
+		}
+		if resp.ContentLength >= 0 && written != resp.ContentLength {
+			discard()
+			return false, fmt.Errorf("incomplete download of %s: expected %d bytes, got %d", artefact, resp.ContentLength, written)
+		}
+		if total >= 0 && offset+written != total {
+			removePartial(partial)
+			return false, fmt.Errorf("incomplete download of %s: expected %d bytes, got %d", artefact, total, offset+written)
+		}
+		logger.Info("Successfully downloaded artefact", "event", "download_complete", "status", resp.StatusCode, "bytes", written)
+		cfg.events.emit(event{Event: "download_complete", Artefact: src.label(artefact), URL: url, Status: resp.StatusCode, Bytes: written})
+		if partial != "" {
+			os.Remove(validatorPath(partial))
+		}
+
+		checksum := cfg.checksumFor(spec)
+		if checksum == "" && lookup != nil {
+			if checksum, err = lookup(artefact); err != nil {
+				os.Remove(tmpFile)
+				return false, err
+			}
+		}
+		if checksum == "" && cfg.checksumCompanion {
+			companionURL, err := resolve(tag, artefact+".sha256")
+			if err == nil {
+				checksum, err = fetchCompanionChecksum(ctx, cfg.client, logger, src.provider, companionURL)
+			}
+			if err != nil {
+				os.Remove(tmpFile)
+				return false, err
+			}
+		}
+		if checksum != "" {
+			algorithm, err := verifyChecksum(tmpFile, checksum)
+			if err != nil {
+				os.Remove(tmpFile)
+				wrapped := fmt.Errorf("checksum verification failed for %s: %v", artefact, err)
+				var mismatch *checksumMismatchError
+				if errors.As(err, &mismatch) {
+					return false, &checksumMismatchError{wrapped, mismatch.actual}
+				}
+				return false, wrapped
+			}
+			logger.Info("Verified checksum", "algorithm", algorithm)
+		}
+
+		if cfg.signature != nil {
+			sigURL, err := resolve(tag, artefact+cfg.signature.suffix)
+			var sig []byte
+			if err == nil {
+				sig, err = fetchCompanion(ctx, cfg.client, logger, src.provider, sigURL)
+			}
+			if err == nil {
+				err = cfg.signature.verify(tmpFile, sig)
+			}
+			if err != nil {
+				os.Remove(tmpFile)
+				return false, fmt.Errorf("signature verification failed for %s: %v", artefact, err)
+			}
+			logger.Info("Verified signature", "scheme", cfg.signature.scheme)
+		}
+
+		if cfg.contentFreshness && statErr == nil && !forced && sameContent(tmpFile, localFilePath, stored) {
+			os.Remove(tmpFile)
+			logger.Info("Content is unchanged; keeping the current file", "event", "skip")
+			// Store the new validators, so that the next check doesn't download again.
+			if cfg.storage.local() {
+				if err := writeETag(localFilePath, resp.Header.Get("ETag")); err != nil {
+					logger.Warn("Failed to store ETag", "error", err)
+				}
+				lm, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+				// A file in the content-addressed cache may be shared.
+				if mtime, ok := cfg.mtimeFor(lm, fi.ModTime); ok && cfg.casDir == "" {
+					if err := os.Chtimes(localFilePath, time.Now(), mtime); err != nil {
+						logger.Warn("Failed to update mod time", "event", "mtime", "path", localFilePath, "error", err)
+					}
+				}
+			}
+			state := stateEntry{ETag: resp.Header.Get("ETag"), Size: fi.Size}
+			state.LastModified, _ = time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+			if stored != nil && stored.Checksum != "" {
+				state.Checksum = stored.Checksum
+			} else if digest, err := hashFile(localFilePath, sha256.New()); err == nil {
+				state.Checksum = hex.EncodeToString(digest)
+			}
+			cfg.state.set(src.stateKey(spec), state)
+			return false, nil
+		}
+
+		if cfg.keepBackup {
+			if err := backupArtefact(localFilePath); err != nil {
+				os.Remove(tmpFile)
+				return false, fmt.Errorf("error backing up %s: %v", localFilePath, err)
+			}
+		}
+
+		if !cfg.storage.local() {
+			info := objectInfo{Size: offset + written, ETag: resp.Header.Get("ETag")}
+			info.ModTime, _ = time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+			if err := cfg.storage.put(ctx, tmpFile, localFilePath, info); err != nil {
+				return false, fmt.Errorf("error uploading %s: %v", artefact, err)
+			}
+			logger.Info("Uploaded artefact", "event", "upload")
+			return true, nil
+		}
+		mode := cfg.fileMode
+		if cfg.executable(spec) {
+			// Everyone who may read the artefact may execute it.
+			mode |= (mode & 0444) >> 2
+		}
+		state := stateEntry{ETag: resp.Header.Get("ETag"), Size: offset + written, Checksum: checksum}
+		if lm := resp.Header.Get("Last-Modified"); lm != "" {
+			if state.LastModified, err = time.Parse(http.TimeFormat, lm); err != nil {
+				logger.Warn("Error parsing Last-Modified header", "last_modified", lm, "error", err)
+			}
+		}
+		var previous time.Time
+		if statErr == nil {
+			previous = fi.ModTime
+		}
+		mtime, setMtime := cfg.mtimeFor(state.LastModified, previous)
+		// setTimes applies the mod time; some FUSE mounts and gateways can't
+		// set it, but the artefact is in place anyway.
+		setTimes := func(name string) {
+			if !setMtime {
+				return
+			}
+			if err := os.Chtimes(name, time.Now(), mtime); err != nil {
+				logger.Warn("Failed to update mod time", "event", "mtime", "path", localFilePath, "error", err)
+			}
+		}
+
+		if cfg.casDir != "" {
+			// The cached file is shared by every destination with the same
+			// content, so only a newly cached one gets the mode and mod time
+			// of this artefact.
+			cached, err := storeInCAS(cfg.casDir, tmpFile, localFilePath, cfg.dirMode, func(name string) error {
+				if err := os.Chmod(name, mode); err != nil {
+					return fmt.Errorf("error setting mode %v: %v", mode, err)
+				}
+				setTimes(name)
+				return nil
+			})
+			if err != nil {
+				os.Remove(tmpFile)
+				return false, fmt.Errorf("error storing %s in the CAS directory: %v", artefact, err)
+			}
+			logger.Info("Linked artefact to the content-addressed cache", "event", "cas", "already_cached", cached)
+		} else {
+			if err := cfg.storage.put(ctx, tmpFile, localFilePath, objectInfo{}); err != nil {
+				os.Remove(tmpFile)
+				return false, fmt.Errorf("error moving file %s to %s: %v", tmpFile, localFilePath, err)
+			}
+			logger.Info("Moved tmp file into place", "event", "rename", "from", tmpFile, "to", localFilePath)
+			if err := os.Chmod(localFilePath, mode); err != nil {
+				return true, fmt.Errorf("error setting mode %v of %s: %v", mode, artefact, err)
+			}
+			setTimes(localFilePath)
+		}
+
+		if err := writeETag(localFilePath, resp.Header.Get("ETag")); err != nil {
+			logger.Warn("Failed to store ETag", "error", err)
+		}
+
+		if state.Checksum == "" {
+			if digest, err := hashFile(localFilePath, sha256.New()); err == nil {
+				state.Checksum = hex.EncodeToString(digest)
+			}
+		}
+		cfg.state.set(src.stateKey(spec), state)
+
+		if cfg.extractFor(spec) && isArchive(artefact) {
+			if err := extractArchive(localFilePath, filepath.Dir(localFilePath), cfg.dirMode); err != nil {
+				return true, fmt.Errorf("error extracting %s: %v", artefact, err)
+			}
+			logger.Info("Extracted archive", "event", "extract", "to", filepath.Dir(localFilePath))
+			if !cfg.keepArchive {
+				if err := os.Remove(localFilePath); err != nil {
+					return true, fmt.Errorf("error removing archive %s: %v", localFilePath, err)
+				}
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// looksLikeHTML reports whether a response is an HTML page, judging by its
+// Content-Type header and the first bytes of its body.
+func looksLikeHTML(contentType string, head []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "text/html" {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(head), "text/html")
+}
+
+// checkContentType returns an error unless the media type of a response
+// matches one of the accepted types.
+func checkContentType(contentType string, accepted []string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type %q, expected %s", contentType, strings.Join(accepted, " or "))
+	}
+	if !matchesAny(accepted, mediaType) {
+		return fmt.Errorf("unexpected content type %s, expected %s", mediaType, strings.Join(accepted, " or "))
+	}
+	return nil
+}
+
+// defaultURLTemplate downloads from the latest GitHub release, or from the
+// release with the given tag.
+const defaultURLTemplate = "https://github.com/{{.Owner}}/{{.Repo}}/releases/" +
+	"{{if .Tag}}download/{{.Tag}}{{else}}latest/download{{end}}/{{.Artefact}}"
+
+// urlData holds the values available to BASE_URL_TEMPLATE.
+type urlData struct {
+	Owner    string
+	Repo     string
+	Tag      string
+	Artefact string
+}
+
+// sameContent reports whether the downloaded file tmpFile has the same
+// SHA-256 as the stored artefact, which is taken from its state if known and
+// computed from the local file otherwise.
+func sameContent(tmpFile, localFilePath string, stored *stateEntry) bool {
+	if stored != nil && stored.Checksum != "" {
+		_, err := verifyChecksum(tmpFile, stored.Checksum)
+		return err == nil
+	}
+	current, err := hashFile(localFilePath, sha256.New())
+	if err != nil {
+		return false
+	}
+	downloaded, err := hashFile(tmpFile, sha256.New())
+	return err == nil && bytes.Equal(current, downloaded)
+}
+
+// skewed reports whether the local mod time is ahead of the remote
+// Last-Modified time by more than the allowed skew and the artefact should be
+// downloaded again. Such a mod time hides updates from the freshness check.
+func (cfg *config) skewed(logger *slog.Logger, local time.Time, lastModified string) bool {
+	if cfg.maxModTimeSkew == 0 {
+		return false
+	}
+	remote, err := time.Parse(http.TimeFormat, lastModified)
+	if err != nil {
+		return false
+	}
+	if skew := local.Sub(remote); skew > cfg.maxModTimeSkew {
+		logger.Warn("Local mod time is implausibly ahead of the remote one", "local_mod_time", local,
+			"remote_mod_time", remote, "skew", skew.Round(time.Second), "redownload", cfg.redownloadOnSkew)
+		return cfg.redownloadOnSkew
+	}
+	return false
+}
+
+// The MTIME_SOURCE values.
+const (
+	mtimeRemote   = "remote"
+	mtimeNow      = "now"
+	mtimePreserve = "preserve"
+)
+
+// mtimeFor returns the mod time a downloaded artefact gets per MTIME_SOURCE,
+// given the remote Last-Modified time and the mod time of the file it
+// replaces, either of which may be zero. It reports false to leave the mod
+// time as is.
+func (cfg *config) mtimeFor(lastModified, previous time.Time) (time.Time, bool) {
+	switch cfg.mtimeSource {
+	case mtimeNow:
+		return time.Now(), true
+	case mtimePreserve:
+		return previous, !previous.IsZero()
+	default:
+		return lastModified, !lastModified.IsZero()
+	}
+}
+
+// remoteNewer reports whether the Last-Modified header of resp is after
+// localModTime. A missing or invalid header counts as newer.
+func remoteNewer(resp *http.Response, localModTime time.Time) bool {
+	remoteModTime, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	return err != nil || remoteModTime.After(localModTime)
+}
+
+// releaseURL renders the download URL of an artefact from the URL template.
+func releaseURL(cfg *config, src *source, tag, artefact string) (string, error) {
+	return renderURL(cfg.urlTemplate, src, tag, artefact)
+}
+
+// renderURL renders a URL template for an artefact.
+func renderURL(t *template.Template, src *source, tag, artefact string) (string, error) {
+	var b strings.Builder
+	data := urlData{
+		Owner:    src.owner,
+		Repo:     src.repo,
+		Tag:      url.PathEscape(tag),
+		Artefact: artefact,
+	}
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering URL for %s: %v", artefact, err)
+	}
+	return b.String(), nil
+}
+
+// job is an artefact to be checked by the worker pool.
+type job struct {
+	src     *source
+	resolve resolver
+	lookup  manifestLookup
+	spec    Artefact
+	// release is the tag of the release, if known.
+	release string
+}
+
+// checkAndDownload runs one check of the artefacts of sources and returns the
+// artefacts that changed. Failures of individual artefacts don't stop the
+// others; they are logged and reported together in the returned error.
+func checkAndDownload(ctx context.Context, cfg *config, sources []*source) (changed []string, err error) {
+	now := time.Now()
+	ctx, sp := cfg.tracer.startSpan(ctx, "check")
+	defer func() {
+		cfg.status.checkDone(now, err)
+		sp.finish(err)
+		cfg.tracer.export(ctx, cfg.client)
+	}()
+
+	var (
+		jobs    []job
+		updated []job
+		failed  []string
+		results []result
+	)
+	if cfg.dryRun {
+		slog.Info("Dry run enabled; no files will be written")
+	}
+	if cfg.artefactsFile != nil {
+		if err := cfg.artefactsFile.load(); err != nil {
+			slog.Warn("Failed to reload artefacts file; keeping the previous list", "path", cfg.artefactsFile.path, "error", err)
+		}
+	}
+	if err := cfg.disabled.load(); err != nil {
+		slog.Warn("Failed to reload disabled artefacts file; keeping the previous list", "path", cfg.disabled.path, "error", err)
+	}
+	for _, src := range sources {
+		if !cfg.dryRun {
+			if err := os.MkdirAll(src.downloadPath, cfg.dirMode); err != nil {
+				return nil, fmt.Errorf("failed to create download directory %q: %v", src.downloadPath, err)
+			}
+		}
+
+		srcJobs, err := sourceJobs(ctx, cfg, src, now)
+		if err != nil {
+			slog.Error("Failed to check source", "event", "error", "owner", src.owner, "repo", src.repo, "error", err)
+			failed = append(failed, src.owner+"/"+src.repo)
+			results = append(results, newResult(src.owner+"/"+src.repo, false, err))
+			for _, a := range src.artefacts {
+				cfg.status.record(src.label(a.Name), src.localPath(a), false, cfg.dryRun, err)
+			}
+			continue
+		}
+		jobs = append(jobs, srcJobs...)
+	}
+	jobs = dedupJobs(jobs)
+	// Sources whose artefacts couldn't be determined count as one failure each.
+	total := len(jobs) + len(failed)
+
+	queue := make(chan job)
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for i := 0; i < cfg.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				artefact := j.src.label(j.spec.Name)
+				start := time.Now()
+				ctx, dsp := cfg.tracer.startSpan(ctx, "download")
+				dsp.set("artefact.name", artefact)
+				ok, err := download(ctx, cfg, j.src, j.resolve, j.lookup, j.spec)
+				dsp.set("artefact.changed", ok)
+				dsp.set("artefact.skipped", err == nil && !ok)
+				dsp.finish(err)
+				cfg.metrics.record(artefact, ok, err, time.Since(start))
+				cfg.status.record(artefact, j.src.localPath(j.spec), ok, cfg.dryRun, err)
+				if err != nil {
+					slog.Error("Failed to download artefact", "event", "error", "artefact", artefact, "error", err)
+					cfg.events.emit(event{Event: "error", Artefact: artefact, Error: err.Error()})
+				}
+				mu.Lock()
+				r := newResult(artefact, ok, err)
+				if ok && !cfg.dryRun {
+					r.Tag = j.release
+					if fi, err := cfg.storage.stat(ctx, j.src.localPath(j.spec)); err == nil {
+						r.Size = fi.Size
+					}
+				}
+				results = append(results, r)
+				if err != nil {
+					failed = append(failed, artefact)
+				}
+				if ok {
+					changed = append(changed, artefact)
+					updated = append(updated, j)
+				}
+				if err == nil && !cfg.dryRun {
+					if ok {
+						cfg.unverified.done(j.src.stateKey(j.spec))
+					}
+					j.src.lastChecked[cmp.Or(j.spec.pattern, j.spec.Name)] = now
+					j.src.succeeded(cmp.Or(j.spec.pattern, j.spec.Name))
+				} else if err != nil && !cfg.dryRun && cfg.failureBackoff {
+					j.src.failed(cmp.Or(j.spec.pattern, j.spec.Name))
+				}
+				mu.Unlock()
+				if cfg.manifest != nil && err == nil && !cfg.dryRun {
+					url, _ := j.resolve(j.src.tagFor(j.spec), j.spec.Name)
+					cfg.manifest.update(j, url, ok)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, j := range jobs {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if !cfg.dryRun {
+		if err := cfg.state.save(); err != nil {
+			slog.Warn("Failed to save state", "error", err)
+		}
+		if cfg.manifest != nil {
+			if err := cfg.manifest.save(); err != nil {
+				slog.Warn("Failed to save manifest", "error", err)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return changed, fmt.Errorf("check aborted: %v", ctx.Err())
+	}
+
+	if cfg.dryRun {
+		slog.Info("Dry run complete", "would_download", len(changed), "checked", len(jobs))
+	} else {
+		updateSymlinks(cfg, updated)
+		if cfg.keepVersions > 0 {
+			pruneOldVersions(jobs, updated, cfg.keepVersions)
+		}
+		if cfg.postDownloadHook != "" && len(changed) > 0 {
+			runHook(ctx, cfg.postDownloadHook, changed)
+		}
+		for _, n := range cfg.notifiers {
+			n.notify(ctx, cfg.client, results)
+		}
+		outcomes := make(map[string]int)
+		var size int64
+		for _, r := range results {
+			outcomes[r.Outcome]++
+			size += r.Size
+		}
+		sp.set("artefacts.checked", total)
+		sp.set("artefacts.downloaded", outcomes[outcomeDownloaded])
+		sp.set("artefacts.failed", outcomes[outcomeFailed])
+		slog.Info("Check complete", "event", "summary", "checked", total, "downloaded", outcomes[outcomeDownloaded],
+			"skipped", outcomes[outcomeSkipped], "failed", outcomes[outcomeFailed], "bytes", size,
+			"duration", time.Since(now).Round(time.Millisecond))
+	}
+
+	if len(failed) > 0 {
+		return changed, fmt.Errorf("%d of %d artefacts failed to download: %s", len(failed), total, strings.Join(failed, ", "))
+	}
+	return changed, nil
+}
+
+// sourceJobs resolves the artefacts of a source that are due at now,
+// expanding glob patterns.
+func sourceJobs(ctx context.Context, cfg *config, src *source, now time.Time) ([]job, error) {
+	resolve := func(tag, name string) (string, error) {
+		return releaseURL(cfg, src, tag, name)
+	}
+	var artefacts []Artefact
+	for _, a := range src.artefacts {
+		if a.Disabled || cfg.disabled.has(src, a.Name) {
+			slog.Info("Artefact is disabled; skipping", "event", "skip", "artefact", src.label(a.Name))
+			continue
+		}
+		if !src.due(a, now) {
+			slog.Debug("Artefact is not due yet; skipping", "event", "skip", "artefact", src.label(a.Name),
+				"interval", a.Interval, "next_check", src.lastChecked[a.Name].Add(a.Interval).Format(time.RFC3339))
+			continue
+		}
+		if src.backingOff(a.Name) {
+			slog.Info("Artefact is backing off after failures; skipping", "event", "skip", "artefact", src.label(a.Name),
+				"failures", src.backoff[a.Name].failures)
+			continue
+		}
+		artefacts = append(artefacts, a)
+	}
+	// Glob patterns need the release's asset list, and the age check its
+	// publication date.
+	releases := make(map[string]*release)
+	// unavailableReleases holds the errors of releases that couldn't be fetched
+	// because of a network or server error, whose artefacts fall back to
+	// their mirrors.
+	unavailableReleases := make(map[string]error)
+	for _, a := range artefacts {
+		tag := src.tagFor(a)
+		if _, ok := releases[tag]; ok || unavailableReleases[tag] != nil ||
+			(!src.provider.requiresAPI() && !isPattern(a.Name) && cfg.minReleaseAge == 0) {
+			continue
+		}
+		r, err := src.provider.fetchRelease(ctx, cfg.client, src.owner, src.repo, tag)
+		var unavailableErr *unavailableError
+		if err != nil && errors.As(err, &unavailableErr) && cfg.minReleaseAge == 0 && hasMirrors(src, artefacts, tag) {
+			// Without the release, its age can't be checked, so this only
+			// applies without MIN_RELEASE_AGE.
+			slog.Warn("Failed to fetch release information; trying the mirrors of its artefacts", "event", "mirror",
+				"owner", src.owner, "repo", src.repo, "release", cmp.Or(tag, "latest"), "error", err)
+			unavailableReleases[tag] = unavailable(ctx, fmt.Errorf("failed to fetch release information: %v", err))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch release information: %v", err)
+		}
+		releases[tag] = r
+	}
+	if src.provider.requiresAPI() {
+		resolve = func(tag, name string) (string, error) {
+			if r := releases[tag]; r != nil {
+				return r.assetURL(name)
+			}
+			return "", unavailableReleases[tag]
+		}
+	}
+
+	// The checksum manifest of each release is fetched once, when the
+	// first artefact of the release was downloaded.
+	lookups := make(map[string]manifestLookup)
+	lookupFor := func(tag string) manifestLookup {
+		if cfg.checksumManifest == "" {
+			return nil
+		}
+		if lookups[tag] == nil {
+			logger := slog.With("artefact", src.label(cfg.checksumManifest))
+			lookups[tag] = newManifestLookup(ctx, cfg.client, logger, src.provider, cfg.checksumManifest, func(name string) (string, error) {
+				return resolve(tag, name)
+			}, cfg.manifestSignature)
+		}
+		return lookups[tag]
+	}
+
+	var jobs []job
+	for _, a := range artefacts {
+		tag := src.tagFor(a)
+		r := releases[tag]
+		if r != nil {
+			tag = r.TagName
+		}
+		if r != nil && cfg.minReleaseAge > 0 && time.Since(r.PublishedAt) < cfg.minReleaseAge {
+			slog.Info("Release is too new; skipping until the next check", "event", "skip", "artefact", src.label(a.Name),
+				"release", r.TagName, "published_at", r.PublishedAt, "min_age", cfg.minReleaseAge)
+			continue
+		}
+		if !isPattern(a.Name) {
+			jobs = append(jobs, job{src, resolve, lookupFor(src.tagFor(a)), a, tag})
+			continue
+		}
+		if err := unavailableReleases[src.tagFor(a)]; err != nil {
+			// Patterns can't be matched without the asset list, nor against mirrors.
+			noRelease := func(string, string) (string, error) { return "", err }
+			a.Mirrors = nil
+			jobs = append(jobs, job{src, noRelease, nil, a, tag})
+			continue
+		}
+		names := releases[src.tagFor(a)].matchAssets(a.Name)
+		if len(names) == 0 && cfg.requireAll {
+			// Checking the pattern fails like an artefact missing upstream.
+			noMatch := func(string, string) (string, error) {
+				return "", fmt.Errorf("no asset of release %s matches %s", tag, a.Name)
+			}
+			jobs = append(jobs, job{src, noMatch, nil, a, tag})
+		}
+		for _, name := range names {
+			if matchesAny(cfg.exclude, name) {
+				slog.Debug("Asset is excluded; skipping", "event", "skip", "artefact", src.label(name), "pattern", a.Name)
+				continue
+			}
+			if cfg.isCompanion(name) {
+				slog.Debug("Asset verifies other assets; skipping", "event", "skip", "artefact", src.label(name), "pattern", a.Name)
+				continue
+			}
+			if cfg.disabled.has(src, name) {
+				slog.Info("Artefact is disabled; skipping", "event", "skip", "artefact", src.label(name), "pattern", a.Name)
+				continue
+			}
+			match := a
+			match.Name = name
+			match.pattern = a.Name
+			jobs = append(jobs, job{src, resolve, lookupFor(src.tagFor(a)), match, tag})
+		}
+	}
+	return jobs, nil
+}
+
+// hasMirrors reports whether an artefact of the release with the given tag
+// has mirrors to fall back to.
+func hasMirrors(src *source, artefacts []Artefact, tag string) bool {
+	return slices.ContainsFunc(artefacts, func(a Artefact) bool {
+		return src.tagFor(a) == tag && len(a.Mirrors) > 0 && !isPattern(a.Name)
+	})
+}
+
+// dedupJobs drops jobs that write the same destination file as an earlier
+// job, e.g. of an artefact listed both by name and by a pattern, so that two
+// workers never race on the same file.
+func dedupJobs(jobs []job) []job {
+	type target struct {
+		job job
+		url string
+	}
+	seen := make(map[string]target)
+	deduped := jobs[:0:0]
+	for _, j := range jobs {
+		dest := j.src.localPath(j.spec)
+		url, _ := j.resolve(j.src.tagFor(j.spec), j.spec.Name)
+		first, ok := seen[dest]
+		if !ok {
+			seen[dest] = target{j, url}
+			deduped = append(deduped, j)
+			continue
+		}
+		if url == first.url {
+			slog.Info("Artefact is listed more than once; downloading it once", "event", "skip",
+				"artefact", j.src.label(j.spec.Name), "path", dest)
+		} else {
+			slog.Warn("Artefacts have the same destination; only downloading the first", "event", "skip",
+				"artefact", j.src.label(j.spec.Name), "path", dest, "url", url,
+				"first", first.job.src.label(first.job.spec.Name), "first_url", first.url)
+		}
+	}
+	return deduped
+}
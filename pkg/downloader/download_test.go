@@ -0,0 +1,321 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	remoteModTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "artefact", remoteModTime, strings.NewReader("new"))
+	}
+
+	tests := []struct {
+		name string
+		// localModTime is the mod time of the existing file.
+		localModTime time.Time
+		handler      http.HandlerFunc
+		wantChanged  bool
+		wantErr      bool
+		wantContent  string
+	}{
+		{
+			name:         "fresh",
+			localModTime: remoteModTime.Add(time.Minute),
+			handler:      serve,
+			wantContent:  "old",
+		},
+		{
+			name:         "stale",
+			localModTime: remoteModTime.Add(-time.Minute),
+			handler:      serve,
+			wantChanged:  true,
+			wantContent:  "new",
+		},
+		{
+			name:         "not found",
+			localModTime: remoteModTime.Add(-time.Minute),
+			handler:      http.NotFound,
+			wantErr:      true,
+			wantContent:  "old",
+		},
+		{
+			name:         "truncated",
+			localModTime: remoteModTime.Add(-time.Minute),
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// The connection is closed after fewer bytes than announced.
+				w.Header().Set("Content-Length", "100")
+				w.Write([]byte("new"))
+			},
+			wantErr:     true,
+			wantContent: "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "artefact")
+			if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, tt.localModTime, tt.localModTime); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := DefaultOptions()
+			opts.DownloadPath = dir
+			opts.URLTemplate = srv.URL + "/{{.Artefact}}"
+			opts.Artefacts = []Artefact{{Name: "artefact"}}
+			opts.MaxRetries = 0
+			opts.DiskSpaceMargin = 0
+			d, err := New(opts)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			changed, err := d.DownloadOne(context.Background(), Artefact{Name: "artefact"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadOne error = %v, want error %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("DownloadOne changed = %v, want %v", changed, tt.wantChanged)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, []byte(tt.wantContent)) {
+				t.Errorf("artefact contains %q, want %q", data, tt.wantContent)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), tempPrefix) {
+					t.Errorf("temp file %s was left behind", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadCAS(t *testing.T) {
+	remoteModTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cachedModTime := remoteModTime.Add(-24 * time.Hour)
+	executable := true
+
+	tests := []struct {
+		name string
+		// precached stores the content in the cache with mode 0600 first.
+		precached bool
+		artefacts []Artefact
+		wantMode  os.FileMode
+		// wantModTime is the mod time of the cached file afterwards.
+		wantModTime time.Time
+	}{
+		{
+			name:        "new blob gets the mode of its artefact",
+			artefacts:   []Artefact{{Name: "tool", Executable: &executable}},
+			wantMode:    0755,
+			wantModTime: remoteModTime,
+		},
+		{
+			name:        "second destination keeps the mode of the blob",
+			artefacts:   []Artefact{{Name: "data"}, {Name: "tool", Executable: &executable}},
+			wantMode:    0644,
+			wantModTime: remoteModTime,
+		},
+		{
+			name:        "already cached blob is linked",
+			precached:   true,
+			artefacts:   []Artefact{{Name: "data"}, {Name: "tool", Executable: &executable}},
+			wantMode:    0600,
+			wantModTime: cachedModTime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "artefact", remoteModTime, strings.NewReader("content"))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			casDir := filepath.Join(t.TempDir(), "cas")
+			sum := sha256.Sum256([]byte("content"))
+			digest := hex.EncodeToString(sum[:])
+			blob := filepath.Join(casDir, "sha256", digest[:2], digest)
+			if tt.precached {
+				if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(blob, []byte("content"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(blob, cachedModTime, cachedModTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := DefaultOptions()
+			opts.DownloadPath = dir
+			opts.URLTemplate = srv.URL + "/{{.Artefact}}"
+			opts.Artefacts = tt.artefacts
+			opts.CASDir = casDir
+			opts.MaxRetries = 0
+			opts.DiskSpaceMargin = 0
+			d, err := New(opts)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			for _, a := range tt.artefacts {
+				if _, err := d.DownloadOne(context.Background(), a); err != nil {
+					t.Fatalf("DownloadOne %s: %v", a.Name, err)
+				}
+			}
+			fi, err := os.Stat(blob)
+			if err != nil {
+				t.Fatalf("content is not cached: %v", err)
+			}
+			if runtime.GOOS != "windows" && fi.Mode().Perm() != tt.wantMode {
+				t.Errorf("cached file has mode %v, want %v", fi.Mode().Perm(), tt.wantMode)
+			}
+			if !fi.ModTime().Equal(tt.wantModTime) {
+				t.Errorf("cached file has mod time %v, want %v", fi.ModTime(), tt.wantModTime)
+			}
+			for _, a := range tt.artefacts {
+				dfi, err := os.Stat(filepath.Join(dir, a.Name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !os.SameFile(fi, dfi) {
+					t.Errorf("%s is not linked to the cached file", a.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckComplete(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	checksum := hex.EncodeToString(sum[:])
+	ownFiles := map[string]string{
+		".tool.etag":                        `"etag"`,
+		"tool" + backupSuffix:               "old",
+		tempPrefix + "tool" + partialSuffix: "cont",
+		".lock":                             "",
+		stateFileName:                       "{}",
+		manifestFileName:                    "{}",
+	}
+
+	tests := []struct {
+		name      string
+		files     map[string]string
+		artefacts []Artefact
+		wantErr   bool
+	}{
+		{
+			name:      "all artefacts verified",
+			files:     map[string]string{"tool": "content"},
+			artefacts: []Artefact{{Name: "tool", Checksum: checksum}},
+		},
+		{
+			name:      "missing artefact",
+			artefacts: []Artefact{{Name: "tool", Checksum: checksum}},
+			wantErr:   true,
+		},
+		{
+			name:      "corrupt artefact",
+			files:     map[string]string{"tool": "corrupt"},
+			artefacts: []Artefact{{Name: "tool", Checksum: checksum}},
+			wantErr:   true,
+		},
+		{
+			name:      "disabled artefact",
+			artefacts: []Artefact{{Name: "tool", Disabled: true}},
+		},
+		{
+			name:      "pattern next to own files",
+			files:     map[string]string{"tool": "content"},
+			artefacts: []Artefact{{Name: "*"}},
+		},
+		{
+			name:      "pattern matching only own files",
+			artefacts: []Artefact{{Name: "*"}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, content := range ownFiles {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := DefaultOptions()
+			opts.DownloadPath = dir
+			opts.URLTemplate = "http://127.0.0.1:1/{{.Artefact}}"
+			opts.Artefacts = tt.artefacts
+			d, err := New(opts)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			if err := d.Complete(); (err != nil) != tt.wantErr {
+				t.Errorf("Complete error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	body := bytes.Repeat([]byte("artefact"), 8<<20/8)
+	for _, size := range []int{4 << 10, defaultCopyBufferSize, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			pool := newBufferPool(size)
+			out, err := os.Create(filepath.Join(b.TempDir(), "artefact"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer out.Close()
+			b.SetBytes(int64(len(body)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := out.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := copyBuffered(out, bytes.NewReader(body), pool); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
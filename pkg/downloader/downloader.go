@@ -0,0 +1,654 @@
+// Package downloader checks GitHub and GitLab releases or plain URLs for new
+// artefacts and downloads them, as the artifact-downloader command does.
+//
+// A Downloader is configured with Options, which start from DefaultOptions:
+//
+//	opts := downloader.DefaultOptions()
+//	opts.DownloadPath = "/data"
+//	opts.Sources = []downloader.Source{{Owner: "cli", Repo: "cli"}}
+//	opts.Artefacts = []downloader.Artefact{{Name: "gh_*_linux_amd64.tar.gz"}}
+//	d, err := downloader.New(opts)
+//	if err != nil {
+//		return err
+//	}
+//	defer d.Close()
+//	err = d.Check(ctx)
+package downloader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Options configures a Downloader. Downloaders created from different
+// options don't share settings, so several of them may run in one process.
+type Options struct {
+	// DownloadPath is the directory the artefacts are saved to. Required.
+	DownloadPath string
+	// Sources are the repositories to download from. Without any, the
+	// artefacts are downloaded with URLTemplate alone.
+	Sources []Source
+	// Artefacts are downloaded from the sources that list none of their own.
+	Artefacts []Artefact
+	// ArtefactsFile replaces Artefacts with a file listing one artefact per
+	// line, which is reloaded before every check once it changed.
+	ArtefactsFile string
+	// LookupVariable resolves ${VAR} references in artefact names and
+	// destinations, e.g. os.LookupEnv. ${OS} and ${ARCH} are always defined.
+	LookupVariable func(name string) (string, bool)
+	// Exclude lists glob patterns of assets that patterns never match.
+	Exclude []string
+	// Disabled lists names and glob patterns of artefacts that are skipped.
+	// DisabledFile reads them from a file instead, which is reloaded before
+	// every check once it changed.
+	Disabled     []string
+	DisabledFile string
+	// URLTemplate is the text/template of the download URLs. Empty means the
+	// release download URLs of GitHub.
+	URLTemplate string
+
+	// Provider is "github" or "gitlab".
+	Provider           string
+	GitHubToken        string
+	IncludePrereleases bool
+	IncludeDrafts      bool
+	// VersionConstraint selects the highest release whose tag satisfies it,
+	// such as ">=1.2, <2", instead of the latest one. Only the newest page
+	// of releases with a satisfying one is searched, which assumes that the
+	// releases of a version line are published in ascending order.
+	VersionConstraint string
+	GitLabBaseURL     string
+	GitLabToken       string
+
+	// BasicUser, BasicPass, and Headers are sent to all hosts except those
+	// of the provider.
+	BasicUser, BasicPass string
+	Headers              http.Header
+	// NetrcFile is a netrc file with logins by host.
+	NetrcFile string
+	UserAgent string
+	// DisableDecompression asks servers not to apply a content coding, so
+	// that downloads are saved exactly as the remote object.
+	DisableDecompression bool
+	// Proxy selects the proxy of a request; nil connects directly.
+	Proxy func(*http.Request) (*url.URL, error)
+	TLS   TLS
+	// HTTPTimeout limits connecting and waiting for response headers.
+	HTTPTimeout  time.Duration
+	MaxRetries   int
+	MaxRedirects int
+	// RateLimitMaxWait caps how long a request waits for an exhausted GitHub
+	// API rate limit to reset, and RetryAfterMaxWait the delay of a
+	// Retry-After header that is honored.
+	RateLimitMaxWait  time.Duration
+	RetryAfterMaxWait time.Duration
+	// MaxBandwidth limits all downloads together, in bytes per second; zero
+	// means unlimited.
+	MaxBandwidth int64
+
+	// Checksums are the expected hex digests by artefact name.
+	Checksums         map[string]string
+	ChecksumCompanion bool
+	// ChecksumManifest is the name of a release asset listing the checksums
+	// of the others, optionally signed with the key GPGPublicKey.
+	ChecksumManifest string
+	ChecksumRetries  int
+	GPGPublicKey     string
+	// SigningPublicKey verifies the signature of every artefact with the
+	// scheme SignatureType, "cosign" or "minisign". Keys may be given as the
+	// name of a file holding them.
+	SigningPublicKey string
+	SignatureType    string
+
+	Concurrency    int
+	ParallelChunks int
+	// DryRun only logs what would be downloaded.
+	DryRun bool
+	// FailureBackoff skips artefacts that failed, for an increasing number
+	// of checks.
+	FailureBackoff bool
+	Progress       bool
+	// RequireAll fails a check if a pattern matches no asset.
+	RequireAll bool
+	Resume     bool
+	RejectHTML bool
+	// ContentFreshness compares the contents instead of the time of a
+	// download with the local file.
+	ContentFreshness bool
+	MinReleaseAge    time.Duration
+	// MtimeSource is "remote", "now", or "preserve".
+	MtimeSource      string
+	MaxModTimeSkew   time.Duration
+	RedownloadOnSkew bool
+	// DiskSpaceMargin is the free space in bytes kept besides a download.
+	DiskSpaceMargin int64
+	// MaxArtefactSize refuses larger downloads; zero means unlimited.
+	MaxArtefactSize int64
+
+	Extract     bool
+	KeepArchive bool
+	// Executables lists glob patterns of the artefacts made executable.
+	Executables  []string
+	KeepBackup   bool
+	KeepVersions int
+	// Symlinks are the paths of symlinks to the latest artefact by name or
+	// glob pattern.
+	Symlinks       map[string]string
+	CASDir         string
+	FileMode       fs.FileMode
+	DirMode        fs.FileMode
+	CopyBufferSize int
+
+	WriteManifest bool
+	// VerifyOnStart downloads existing files again that don't match their
+	// checksum.
+	VerifyOnStart bool
+	// TempFileMaxAge keeps temp files of other processes that are younger.
+	TempFileMaxAge time.Duration
+	// PostDownloadHook is a shell command run after artefacts changed.
+	PostDownloadHook string
+	NotifyWebhookURL string
+	// NotifyOn is "always", "change", or "failure".
+	NotifyOn        string
+	SlackWebhookURL string
+	// EventSocket is a Unix socket download events are written to.
+	EventSocket string
+	Tracing     *Tracing
+	// S3 uploads the artefacts to a bucket instead of keeping them in
+	// DownloadPath.
+	S3 *S3
+}
+
+// Source is a repository whose artefacts are downloaded into their own
+// directory below the download path.
+type Source struct {
+	Owner string
+	Repo  string
+	// Tag is the release downloaded from; empty means the latest release.
+	Tag string
+	// Directory is relative to the download path; empty means the download
+	// path itself.
+	Directory string
+	// Artefacts default to Options.Artefacts.
+	Artefacts []Artefact
+	// Token replaces the token of the provider for the source.
+	Token string
+}
+
+// TLS configures the TLS settings of the HTTP client.
+type TLS struct {
+	// CAFile holds certificates trusted in addition to the system roots.
+	CAFile     string
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables the verification of all certificates and
+	// InsecureHosts that of the certificates of these host names.
+	InsecureSkipVerify bool
+	InsecureHosts      []string
+}
+
+// DefaultOptions returns the defaults of the command-line tool. Proxies are
+// taken from HTTP_PROXY, HTTPS_PROXY, and NO_PROXY, as by net/http.
+func DefaultOptions() Options {
+	return Options{
+		Provider:          "github",
+		GitLabBaseURL:     "https://gitlab.com",
+		UserAgent:         defaultUserAgent,
+		Proxy:             http.ProxyFromEnvironment,
+		HTTPTimeout:       30 * time.Second,
+		MaxRetries:        3,
+		MaxRedirects:      10,
+		RateLimitMaxWait:  defaultRateLimitWait,
+		RetryAfterMaxWait: defaultRetryAfter,
+		SignatureType:     "cosign",
+		Concurrency:       4,
+		ParallelChunks:    1,
+		MtimeSource:       mtimeRemote,
+		DiskSpaceMargin:   100 << 20,
+		KeepArchive:       true,
+		FileMode:          0644,
+		DirMode:           0755,
+		CopyBufferSize:    defaultCopyBufferSize,
+		NotifyOn:          "always",
+	}
+}
+
+// validate reports the first option with an invalid value.
+func (o *Options) validate() error {
+	switch {
+	case o.DownloadPath == "":
+		return errors.New("download path is required")
+	case o.BasicUser == "" && o.BasicPass != "":
+		return errors.New("basic auth password requires a user")
+	case o.Concurrency < 1:
+		return fmt.Errorf("invalid concurrency %d; must be positive", o.Concurrency)
+	case o.ParallelChunks < 1 || o.ParallelChunks > MaxParallelChunks:
+		return fmt.Errorf("invalid parallel chunks %d; must be between 1 and %d", o.ParallelChunks, MaxParallelChunks)
+	case o.MaxRetries < 0 || o.MaxRedirects < 0 || o.ChecksumRetries < 0 || o.KeepVersions < 0:
+		return errors.New("retries, redirects, and versions to keep must not be negative")
+	case o.HTTPTimeout <= 0:
+		return fmt.Errorf("invalid HTTP timeout %s; must be positive", o.HTTPTimeout)
+	case o.RateLimitMaxWait < 0 || o.RetryAfterMaxWait < 0 || o.MinReleaseAge < 0 || o.MaxModTimeSkew < 0 || o.TempFileMaxAge < 0:
+		return errors.New("durations must not be negative")
+	case o.MaxBandwidth < 0 || o.MaxArtefactSize < 0:
+		return errors.New("bandwidth and artefact size must not be negative")
+	case o.CopyBufferSize < 512 || o.CopyBufferSize > 64<<20:
+		return fmt.Errorf("invalid copy buffer size %d; must be between 512 bytes and 64MB", o.CopyBufferSize)
+	case o.FileMode > 0777 || o.DirMode > 0777:
+		return errors.New("file and directory modes must be permissions such as 0644")
+	case !slices.Contains([]string{mtimeRemote, mtimeNow, mtimePreserve}, o.MtimeSource):
+		return fmt.Errorf("invalid mtime source %q; expected remote, now, or preserve", o.MtimeSource)
+	case o.MaxModTimeSkew > 0 && o.MtimeSource != mtimeRemote:
+		return errors.New("max mod time skew requires the remote mtime source")
+	case o.NotifyWebhookURL != "" && !slices.Contains([]string{"always", "change", "failure"}, o.NotifyOn):
+		return fmt.Errorf("invalid notify on %q; expected always, change, or failure", o.NotifyOn)
+	case o.GPGPublicKey != "" && o.ChecksumManifest == "":
+		return errors.New("GPG public key requires a checksum manifest")
+	}
+	for _, pattern := range o.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+	}
+	for name := range o.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Host", "Content-Length", "Range", "If-None-Match", "If-Modified-Since":
+			return fmt.Errorf("header %s is set by the downloader", name)
+		case "User-Agent":
+			return fmt.Errorf("header %s is set with the user agent option", name)
+		}
+	}
+	// Repositories sharing a directory would overwrite each other's artefacts
+	// and state.
+	dirs := make(map[string]string, len(o.Sources))
+	for _, src := range o.Sources {
+		if src.Directory != "" && !filepath.IsLocal(filepath.FromSlash(src.Directory)) {
+			return fmt.Errorf("directory %q of source %s must be relative to the download path", src.Directory, src.Repo)
+		}
+		dir, repo := path.Clean(filepath.ToSlash(src.Directory)), src.Owner+"/"+src.Repo
+		if other, ok := dirs[dir]; ok && other != repo {
+			return fmt.Errorf("sources %s and %s share the directory %q; give them distinct directories", other, repo, src.Directory)
+		}
+		dirs[dir] = repo
+	}
+	return nil
+}
+
+// Downloader checks the releases of its sources for new artefacts and
+// downloads them.
+type Downloader struct {
+	cfg *config
+}
+
+// New validates opts, loads the state of the download path, and cleans up
+// the temp files left by earlier runs. With VerifyOnStart it also checks the
+// existing files against their checksums.
+func New(opts Options) (*Downloader, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	reqOpts := &requestOptions{
+		userAgent:            opts.UserAgent,
+		disableDecompression: opts.DisableDecompression,
+		maxRedirects:         opts.MaxRedirects,
+		basicUser:            opts.BasicUser,
+		basicPass:            opts.BasicPass,
+		headers:              make(http.Header, len(opts.Headers)),
+	}
+	for name, values := range opts.Headers {
+		for _, v := range values {
+			reqOpts.headers.Add(name, v)
+		}
+	}
+	if len(reqOpts.headers) > 0 {
+		slog.Info("Sending custom headers", "headers", redactHeaders(reqOpts.headers))
+	}
+	if opts.NetrcFile != "" {
+		var err error
+		if reqOpts.credentials, err = loadNetrc(opts.NetrcFile); err != nil {
+			return nil, fmt.Errorf("failed to read netrc file: %v", err)
+		}
+		slog.Info("Using credentials from netrc file", "path", opts.NetrcFile, "machines", len(reqOpts.credentials.machines))
+	}
+
+	cfg := &config{
+		downloadPath:      opts.DownloadPath,
+		checksums:         make(map[string]string, len(opts.Checksums)),
+		checksumCompanion: opts.ChecksumCompanion,
+		checksumManifest:  opts.ChecksumManifest,
+		checksumRetries:   opts.ChecksumRetries,
+		concurrency:       opts.Concurrency,
+		dryRun:            opts.DryRun,
+		failureBackoff:    opts.FailureBackoff,
+		progress:          opts.Progress,
+		requireAll:        opts.RequireAll,
+		extract:           opts.Extract,
+		keepArchive:       opts.KeepArchive,
+		executables:       opts.Executables,
+		exclude:           opts.Exclude,
+		postDownloadHook:  opts.PostDownloadHook,
+		rejectHTML:        opts.RejectHTML,
+		resume:            opts.Resume,
+		contentFreshness:  opts.ContentFreshness,
+		keepBackup:        opts.KeepBackup,
+		casDir:            opts.CASDir,
+		verifyOnStart:     opts.VerifyOnStart,
+		parallelChunks:    opts.ParallelChunks,
+		mtimeSource:       opts.MtimeSource,
+		keepVersions:      opts.KeepVersions,
+		symlinks:          opts.Symlinks,
+		minReleaseAge:     opts.MinReleaseAge,
+		maxModTimeSkew:    opts.MaxModTimeSkew,
+		redownloadOnSkew:  opts.RedownloadOnSkew,
+		diskSpaceMargin:   opts.DiskSpaceMargin,
+		maxSize:           opts.MaxArtefactSize,
+		httpTimeout:       opts.HTTPTimeout,
+		fileMode:          opts.FileMode,
+		dirMode:           opts.DirMode,
+		buffers:           newBufferPool(opts.CopyBufferSize),
+		lookup:            opts.LookupVariable,
+		metrics:           newMetrics(),
+		status:            newStatus(),
+	}
+	for name, digest := range opts.Checksums {
+		digest = strings.ToLower(digest)
+		if _, err := hashForDigest(digest); err != nil {
+			return nil, fmt.Errorf("invalid checksum for %s: %v", name, err)
+		}
+		cfg.checksums[name] = digest
+	}
+	if opts.MaxBandwidth > 0 {
+		cfg.bandwidth = newRateLimiter(opts.MaxBandwidth)
+	}
+
+	p, err := newProvider(opts, reqOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.configureSources(opts, p); err != nil {
+		return nil, err
+	}
+	if cfg.disabled, err = newDisabledList(opts.Disabled, opts.DisabledFile); err != nil {
+		return nil, fmt.Errorf("invalid disabled artefacts: %v", err)
+	}
+	urlTemplate := opts.URLTemplate
+	if urlTemplate == "" {
+		urlTemplate = defaultURLTemplate
+	}
+	if cfg.urlTemplate, err = template.New("url").Parse(urlTemplate); err != nil {
+		return nil, fmt.Errorf("invalid URL template %q: %v", urlTemplate, err)
+	}
+
+	if opts.SigningPublicKey != "" {
+		key, err := readKey(opts.SigningPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing public key: %v", err)
+		}
+		if cfg.signature, err = newSignatureVerifier(opts.SignatureType, key); err != nil {
+			return nil, fmt.Errorf("invalid signing public key for signature type %q: %v", opts.SignatureType, err)
+		}
+	}
+	if opts.GPGPublicKey != "" {
+		key, err := readKey(opts.GPGPublicKey)
+		if err == nil {
+			cfg.manifestSignature, err = newGPGVerifier(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPG public key: %v", err)
+		}
+	}
+	cfg.state = loadState(filepath.Join(cfg.downloadPath, stateFileName), cfg.fileMode)
+	if opts.WriteManifest {
+		cfg.manifest = loadManifest(filepath.Join(cfg.downloadPath, manifestFileName), cfg.fileMode)
+	}
+
+	if opts.NotifyWebhookURL != "" {
+		cfg.notifiers = append(cfg.notifiers, &webhook{url: opts.NotifyWebhookURL, on: opts.NotifyOn})
+	}
+	if opts.SlackWebhookURL != "" {
+		cfg.notifiers = append(cfg.notifiers, &slackWebhook{url: opts.SlackWebhookURL})
+	}
+
+	if cfg.client, err = newClient(opts, reqOpts); err != nil {
+		return nil, err
+	}
+
+	if opts.EventSocket != "" {
+		cfg.events = newEventSocket(opts.EventSocket)
+		slog.Info("Sending events to Unix socket", "path", opts.EventSocket)
+	}
+	if cfg.tracer, err = newOTLPTracer(opts.Tracing); err != nil {
+		return nil, fmt.Errorf("invalid tracing configuration: %v", err)
+	} else if cfg.tracer != nil {
+		slog.Info("Exporting traces", "endpoint", cfg.tracer.endpoint, "service", cfg.tracer.service)
+	}
+
+	cfg.storage = localStorage{}
+	if opts.S3 != nil {
+		s3, err := newS3Storage(cfg.client, cfg.downloadPath, opts.S3)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 storage configuration: %v", err)
+		}
+		if feature := localOnlyFeature(cfg); feature != "" {
+			return nil, fmt.Errorf("%s requires local storage", feature)
+		}
+		slog.Info("Uploading artefacts to S3", "endpoint", s3.endpoint.Redacted(), "bucket", s3.bucket, "prefix", s3.prefix)
+		cfg.storage = s3
+	}
+
+	if !cfg.dryRun {
+		// The root holds the temp files of the state file.
+		dirs := []string{cfg.downloadPath}
+		for _, src := range cfg.sources {
+			for _, dir := range src.dirs() {
+				if !slices.Contains(dirs, dir) {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+		for _, dir := range dirs {
+			cleanupTempFiles(dir, cfg.resume, opts.TempFileMaxAge)
+		}
+	}
+
+	cfg.unverified = &unverifiedSet{keys: make(map[string]bool)}
+	if cfg.verifyOnStart {
+		cfg.unverified = verifyExisting(cfg)
+	}
+	return &Downloader{cfg: cfg}, nil
+}
+
+// newProvider returns the provider of opts.
+func newProvider(opts Options, reqOpts *requestOptions) (provider, error) {
+	switch opts.Provider {
+	case "github":
+		gp := &githubProvider{opts: reqOpts, token: opts.GitHubToken, prereleases: opts.IncludePrereleases, drafts: opts.IncludeDrafts}
+		if opts.VersionConstraint != "" {
+			var err error
+			if gp.constraint, err = parseVersionConstraint(opts.VersionConstraint); err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %v", opts.VersionConstraint, err)
+			}
+		}
+		return gp, nil
+	case "gitlab":
+		return &gitlabProvider{opts: reqOpts, baseURL: strings.TrimSuffix(opts.GitLabBaseURL, "/"), token: opts.GitLabToken}, nil
+	default:
+		return nil, fmt.Errorf("invalid provider %q; expected github or gitlab", opts.Provider)
+	}
+}
+
+// configureSources builds the sources of opts, expanding and validating
+// their artefacts.
+func (cfg *config) configureSources(opts Options, p provider) error {
+	sources := opts.Sources
+	if len(sources) == 0 {
+		sources = []Source{{}}
+	}
+	var listed []*source
+	for _, s := range sources {
+		src := &source{
+			owner:        s.Owner,
+			repo:         s.Repo,
+			releaseTag:   s.Tag,
+			provider:     p,
+			dir:          filepath.ToSlash(s.Directory),
+			downloadPath: filepath.Join(cfg.downloadPath, filepath.FromSlash(s.Directory)),
+			artefacts:    s.Artefacts,
+			lastChecked:  make(map[string]time.Time),
+			backoff:      make(map[string]*backoffState),
+		}
+		if s.Token != "" {
+			src.provider = p.withToken(s.Token)
+		}
+		if len(src.artefacts) == 0 {
+			src.artefacts = opts.Artefacts
+			src.listed = true
+			listed = append(listed, src)
+		}
+		cfg.sources = append(cfg.sources, src)
+	}
+
+	if opts.ArtefactsFile != "" {
+		cfg.artefactsFile = &artefactsFile{path: opts.ArtefactsFile, sources: listed, lookup: opts.LookupVariable}
+		if err := cfg.artefactsFile.load(); err != nil {
+			return fmt.Errorf("invalid artefacts file %q: %v", opts.ArtefactsFile, err)
+		}
+	}
+	for _, src := range cfg.sources {
+		if opts.URLTemplate == "" && (src.owner == "" || src.repo == "") {
+			return errors.New("owner and repository are required without a URL template")
+		}
+		name := cmp.Or(path.Join(src.owner, src.repo), "the URL template")
+		if len(src.artefacts) == 0 {
+			return fmt.Errorf("no artefacts configured for %s", name)
+		}
+		artefacts, err := expandArtefacts(src.artefacts, cfg.lookup)
+		if err == nil {
+			err = ValidateArtefacts(artefacts)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid artefacts of %s: %v", name, err)
+		}
+		src.artefacts = artefacts
+	}
+	return nil
+}
+
+// newClient builds the retrying HTTP client of opts.
+func newClient(opts Options, reqOpts *requestOptions) (*retryClient, error) {
+	tlsOpts := tlsOptions{
+		caFile:             opts.TLS.CAFile,
+		clientCert:         opts.TLS.ClientCert,
+		clientKey:          opts.TLS.ClientKey,
+		insecureSkipVerify: opts.TLS.InsecureSkipVerify,
+	}
+	if tlsOpts.insecureSkipVerify {
+		slog.Warn("TLS certificate verification is disabled; server certificates are NOT verified. " +
+			"Downloads can be intercepted and tampered with. Never use this in production!")
+	}
+	for _, host := range opts.TLS.InsecureHosts {
+		tlsOpts.insecureHosts = append(tlsOpts.insecureHosts, strings.ToLower(host))
+	}
+	if len(tlsOpts.insecureHosts) > 0 {
+		slog.Warn("Certificates of insecure hosts are NOT verified", "hosts", tlsOpts.insecureHosts)
+	}
+	tlsConfig, err := tlsOpts.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+
+	// The timeout covers connecting and waiting for response headers; stalled
+	// response bodies are detected separately while copying.
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 opts.Proxy,
+			TLSClientConfig:       tlsConfig,
+			DialContext:           (&net.Dialer{Timeout: opts.HTTPTimeout}).DialContext,
+			TLSHandshakeTimeout:   opts.HTTPTimeout,
+			ResponseHeaderTimeout: opts.HTTPTimeout,
+			MaxIdleConns:          5,
+			IdleConnTimeout:       30 * time.Second,
+			MaxConnsPerHost:       max(2, opts.Concurrency),
+			DisableCompression:    opts.DisableDecompression,
+		},
+		CheckRedirect: reqOpts.checkRedirect,
+	}
+	return &retryClient{
+		doer:             httpClient,
+		opts:             reqOpts,
+		maxRetries:       opts.MaxRetries,
+		maxRateLimitWait: opts.RateLimitMaxWait,
+		maxRetryAfter:    opts.RetryAfterMaxWait,
+	}, nil
+}
+
+// Check runs one check of all artefacts. Failures of individual artefacts
+// don't stop the others; they are logged and reported together in the
+// returned error. Check must not run concurrently with DownloadOne.
+func (d *Downloader) Check(ctx context.Context) error {
+	_, err := checkAndDownload(ctx, d.cfg, d.cfg.sources)
+	return err
+}
+
+// DownloadOne checks a single artefact of the first source, regardless of
+// its interval and of failures of earlier checks, and reports whether it
+// changed. The artefact need not be configured.
+func (d *Downloader) DownloadOne(ctx context.Context, a Artefact) (bool, error) {
+	artefacts, err := expandArtefacts([]Artefact{a}, d.cfg.lookup)
+	if err == nil {
+		err = ValidateArtefacts(artefacts)
+	}
+	if err != nil {
+		return false, err
+	}
+	src := *d.cfg.sources[0]
+	src.artefacts = artefacts
+	src.lastChecked = make(map[string]time.Time)
+	src.backoff = make(map[string]*backoffState)
+	changed, err := checkAndDownload(ctx, d.cfg, []*source{&src})
+	return len(changed) > 0, err
+}
+
+// SelfTest sends one HEAD request for every artefact, logs the outcome of
+// each, and returns the number of failures.
+func (d *Downloader) SelfTest(ctx context.Context) int {
+	return selfTest(ctx, d.cfg)
+}
+
+// Complete confirms that the file of every artefact exists and matches its
+// checksum, if one is known. Corrupt files are downloaded again by the next
+// check.
+func (d *Downloader) Complete() error {
+	return checkComplete(d.cfg)
+}
+
+// MetricsHandler serves the download statistics in the Prometheus text
+// format.
+func (d *Downloader) MetricsHandler() http.Handler {
+	return d.cfg.metrics
+}
+
+// StatusHandler serves the outcome of the last check of every artefact as
+// JSON.
+func (d *Downloader) StatusHandler() http.Handler {
+	return d.cfg.status.handler(d.cfg.storage)
+}
+
+// Close waits up to five seconds for pending events to be sent.
+func (d *Downloader) Close() error {
+	d.cfg.events.flush(5 * time.Second)
+	return nil
+}
@@ -0,0 +1,35 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// etagPath returns the path of the sidecar file storing the ETag of the
+// artefact at localFilePath.
+func etagPath(localFilePath string) string {
+	return filepath.Join(filepath.Dir(localFilePath), "."+filepath.Base(localFilePath)+".etag")
+}
+
+// readETag returns the ETag stored for the artefact at localFilePath, or an
+// empty string if none is known.
+func readETag(localFilePath string) string {
+	data, err := os.ReadFile(etagPath(localFilePath))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeETag stores the ETag of the artefact at localFilePath. An empty ETag
+// removes a previously stored one so it can't be sent for a different file.
+func writeETag(localFilePath, etag string) error {
+	if etag == "" {
+		if err := os.Remove(etagPath(localFilePath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(etagPath(localFilePath), []byte(etag+"\n"), 0644)
+}
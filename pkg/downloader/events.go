@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxPendingEvents caps the events buffered while the socket is unavailable.
+const maxPendingEvents = 1024
+
+// event is written to the socket as one line of JSON.
+type event struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Artefact string    `json:"artefact"`
+	URL      string    `json:"url,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// eventSocket writes events as newline-delimited JSON to a Unix domain
+// socket from its own goroutine, reconnecting with backoff whenever the
+// connection fails. It is nil unless configured, and emit is a no-op then.
+type eventSocket struct {
+	path    string
+	pending chan event
+	// queued counts the events that are pending or being written.
+	queued sync.WaitGroup
+}
+
+// newEventSocket starts sending events to the Unix socket at path.
+func newEventSocket(path string) *eventSocket {
+	s := &eventSocket{path: path, pending: make(chan event, maxPendingEvents)}
+	go s.run()
+	return s
+}
+
+// emit queues an event without blocking; if the queue is full because the
+// socket is unavailable, the event is dropped.
+func (s *eventSocket) emit(e event) {
+	if s == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	s.queued.Add(1)
+	select {
+	case s.pending <- e:
+	default:
+		s.queued.Done()
+		slog.Debug("Event queue is full; dropping event", "path", s.path, "event", e.Event, "artefact", e.Artefact)
+	}
+}
+
+// flush waits up to timeout for the queued events to be written, so that the
+// events of run once mode aren't lost on exit.
+func (s *eventSocket) flush(timeout time.Duration) {
+	if s == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		s.queued.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Timed out writing events to the event socket", "path", s.path)
+	}
+}
+
+func (s *eventSocket) run() {
+	backoff := time.Second
+	for {
+		conn, err := net.Dial("unix", s.path)
+		if err != nil {
+			slog.Warn("Failed to connect to event socket; retrying", "path", s.path, "error", err, "delay", backoff)
+			time.Sleep(backoff)
+			backoff = min(2*backoff, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		enc := json.NewEncoder(conn)
+		for e := range s.pending {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = enc.Encode(e)
+			s.queued.Done()
+			if err != nil {
+				break
+			}
+		}
+		conn.Close()
+		// The event that failed is lost with the connection.
+		slog.Warn("Lost connection to event socket; reconnecting", "path", s.path, "error", err)
+	}
+}
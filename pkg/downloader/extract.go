@@ -0,0 +1,172 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// isArchive reports whether an artefact is an archive that can be extracted.
+func isArchive(name string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar.xz", ".txz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractArchive unpacks the tar.gz, tar.zst, tar.xz, or zip archive at
+// archivePath into destDir, creating directories with dirMode.
+func extractArchive(archivePath, destDir string, dirMode fs.FileMode) error {
+	if strings.HasSuffix(archivePath, ".zip") {
+		return extractZip(archivePath, destDir, dirMode)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(archivePath, ".zst") || strings.HasSuffix(archivePath, ".tzst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("error reading zstd stream of %s: %v", archivePath, err)
+		}
+		defer zr.Close()
+		r = zr
+	case strings.HasSuffix(archivePath, ".xz") || strings.HasSuffix(archivePath, ".txz"):
+		xr, err := xz.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("error reading xz stream of %s: %v", archivePath, err)
+		}
+		r = xr
+	default:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("error reading gzip stream of %s: %v", archivePath, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return extractTar(r, destDir, dirMode)
+}
+
+func extractTar(r io.Reader, destDir string, dirMode fs.FileMode) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar archive: %v", err)
+		}
+
+		target, err := extractTarget(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, dirMode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeExtractedFile(target, tr, fs.FileMode(hdr.Mode).Perm(), dirMode); err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skipping unsupported archive entry", "entry", hdr.Name, "type", string(hdr.Typeflag))
+		}
+	}
+}
+
+func extractZip(archivePath, destDir string, dirMode fs.FileMode) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error reading zip archive %s: %v", archivePath, err)
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		target, err := extractTarget(destDir, zf.Name)
+		if err != nil {
+			return err
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, dirMode); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("error opening %s in %s: %v", zf.Name, archivePath, err)
+			}
+			err = writeExtractedFile(target, rc, mode.Perm(), dirMode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			slog.Warn("Skipping unsupported archive entry", "entry", zf.Name, "mode", mode.String())
+		}
+	}
+	return nil
+}
+
+// extractTarget returns the path an archive entry is extracted to, rejecting
+// entries that would end up outside of destDir.
+func extractTarget(destDir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("archive entry %q points outside of the extraction directory", name)
+	}
+	return filepath.Join(destDir, name), nil
+}
+
+// writeExtractedFile writes r to path through a temp file, so that readers of
+// path never see a partially extracted file.
+func writeExtractedFile(path string, r io.Reader, perm, dirMode fs.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	buf := buffers.Get().([]byte)
+	_, err = io.CopyBuffer(tmp, r, buf)
+	buffers.Put(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = moveFile(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error extracting %s: %v", path, err)
+	}
+	return nil
+}
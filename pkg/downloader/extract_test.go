@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestArchive writes an archive with a file of the given name for every
+// entry, in the format of the extension of path.
+func writeTestArchive(t *testing.T, path string, entries []string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(path) == ".zip" {
+		zw := zip.NewWriter(f)
+		for _, name := range entries {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("evil"))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 4}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("evil"))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchiveTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		// entry is joined to the directory outside of the extraction
+		// directory if it is absolute.
+		entry   string
+		wantErr bool
+	}{
+		{name: "local", entry: "bin/tool"},
+		{name: "parent", entry: "../evil", wantErr: true},
+		{name: "nested parent", entry: "bin/../../evil", wantErr: true},
+		{name: "absolute", entry: "/abs", wantErr: true},
+	}
+	for _, ext := range []string{".tar.gz", ".zip"} {
+		for _, tt := range tests {
+			t.Run(ext+"/"+tt.name, func(t *testing.T) {
+				outside := t.TempDir()
+				dest := filepath.Join(outside, "dest")
+				if err := os.Mkdir(dest, 0755); err != nil {
+					t.Fatal(err)
+				}
+				entry := tt.entry
+				if filepath.IsAbs(entry) {
+					entry = filepath.ToSlash(filepath.Join(outside, entry))
+				}
+				archive := filepath.Join(t.TempDir(), "archive"+ext)
+				writeTestArchive(t, archive, []string{entry})
+
+				err := extractArchive(archive, dest, 0755)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("extractArchive error = %v, want error %v", err, tt.wantErr)
+				}
+				for _, name := range []string{"evil", "abs"} {
+					if _, err := os.Stat(filepath.Join(outside, name)); !os.IsNotExist(err) {
+						t.Errorf("%s was written outside of the extraction directory", name)
+					}
+				}
+				if !tt.wantErr {
+					if _, err := os.Stat(filepath.Join(dest, entry)); err != nil {
+						t.Errorf("%s was not extracted: %v", entry, err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+// githubProvider downloads from GitHub releases. Without a token assets are
+// downloaded from their public URLs.
+type githubProvider struct {
+	opts  *requestOptions
+	token string
+	// prereleases and drafts widen the latest release to the newest
+	// pre-release or draft.
+	prereleases bool
+	drafts      bool
+	// constraint selects the highest release whose tag satisfies it instead
+	// of the newest one.
+	constraint *versionConstraint
+}
+
+type githubRelease struct {
+	TagName     string    `json:"tag_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	Assets      []struct {
+		Name               string `json:"name"`
+		URL                string `json:"url"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// newRequest asks the API for the raw asset contents if a token is set. The
+// token is only sent to the API and basic auth credentials only to other
+// hosts, such as those of BASE_URL_TEMPLATE and mirrors.
+func (p *githubProvider) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := p.opts.newRequest(ctx, method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	api, _ := url.Parse(githubAPI)
+	switch {
+	case req.URL.Host == api.Host:
+		if p.token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.token)
+			req.Header.Set("Accept", "application/octet-stream")
+		}
+	case req.URL.Host != "github.com":
+		p.opts.setBasicAuth(req)
+	}
+	return req, nil
+}
+
+func (p *githubProvider) checkAuth(resp *http.Response) error {
+	if p.token == "" || resp.Request.Header.Get("Authorization") == "" {
+		return nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("GitHub rejected the token for %s (HTTP status %s); the token is invalid or lacks the contents:read scope",
+			resp.Request.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// requiresAPI is true with a token, since private repositories are only
+// reachable through the REST API, and for pre-releases and drafts, which the
+// public latest/download URL never points at.
+func (p *githubProvider) requiresAPI() bool {
+	return p.token != "" || p.prereleases || p.drafts || p.constraint != nil
+}
+
+func (p *githubProvider) withToken(token string) provider {
+	c := *p
+	c.token = token
+	return &c
+}
+
+// maxReleasePages caps the pages of the releases list searched for a release
+// satisfying the version constraint.
+const maxReleasePages = 10
+
+func (p *githubProvider) fetchRelease(ctx context.Context, c *retryClient, owner, repo, tag string) (*release, error) {
+	// The releases list is sorted by creation date, newest first.
+	list := tag == "" && (p.prereleases || p.drafts || p.constraint != nil)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/releases/latest", githubAPI, owner, repo)
+	if tag != "" {
+		apiURL = fmt.Sprintf("%s/repos/%s/%s/releases/tags/%s", githubAPI, owner, repo, url.PathEscape(tag))
+	} else if list {
+		apiURL = fmt.Sprintf("%s/repos/%s/%s/releases?per_page=100", githubAPI, owner, repo)
+	}
+
+	var gr githubRelease
+	if list {
+		var (
+			found bool
+			best  semver
+		)
+		// The search stops at the first page with a match, as releases of a
+		// version line are created in ascending order.
+		for page, next := 1, apiURL; next != "" && !found && page <= maxReleasePages; page++ {
+			var releases []githubRelease
+			var err error
+			if next, err = p.get(ctx, c, next, &releases); err != nil {
+				return nil, err
+			}
+			for _, r := range releases {
+				if (r.Draft && !p.drafts) || (r.Prerelease && !p.prereleases) {
+					continue
+				}
+				if p.constraint == nil {
+					gr, found = r, true
+					break
+				}
+				v, err := parseSemver(r.TagName)
+				if err != nil || (len(v.pre) > 0 && !p.prereleases) || !p.constraint.allows(v) {
+					continue
+				}
+				if !found || v.compare(best) > 0 {
+					gr, best, found = r, v, true
+				}
+			}
+		}
+		if !found && p.constraint != nil {
+			return nil, fmt.Errorf("no release satisfying %q found in %s", p.constraint.expr, apiURL)
+		} else if !found {
+			return nil, fmt.Errorf("no matching release found in %s", apiURL)
+		}
+	} else if _, err := p.get(ctx, c, apiURL, &gr); err != nil {
+		return nil, err
+	}
+
+	r := &release{TagName: gr.TagName, PublishedAt: gr.PublishedAt}
+	for _, a := range gr.Assets {
+		// API asset URLs only serve the contents to authenticated requests.
+		u := a.URL
+		if p.token == "" {
+			u = a.BrowserDownloadURL
+		}
+		r.Assets = append(r.Assets, asset{Name: a.Name, URL: u})
+	}
+	return r, nil
+}
+
+// get decodes the JSON response of the API at apiURL into v and returns the
+// URL of the next page, if any.
+func (p *githubProvider) get(ctx context.Context, c *retryClient, apiURL string, v any) (string, error) {
+	req, err := p.newRequest(ctx, "GET", apiURL)
+	if err != nil {
+		return "", fmt.Errorf("error creating request for %s: %v", apiURL, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := c.do(slog.Default(), req)
+	if err != nil {
+		return "", unavailable(ctx, fmt.Errorf("error requesting %s: %v", apiURL, err))
+	}
+	defer resp.Body.Close()
+
+	if err := p.checkAuth(resp); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch release from %s: HTTP status %s", apiURL, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("error decoding release from %s: %v", apiURL, err)
+	}
+	return nextPage(resp.Header.Get("Link")), nil
+}
+
+// nextPage returns the URL of the rel="next" link of a Link header.
+func nextPage(link string) string {
+	for _, l := range strings.Split(link, ",") {
+		target, params, _ := strings.Cut(strings.TrimSpace(l), ";")
+		if strings.Contains(params, `rel="next"`) {
+			return strings.Trim(target, "<>")
+		}
+	}
+	return ""
+}
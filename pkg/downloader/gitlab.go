@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// gitlabProvider downloads from the release asset links of a GitLab
+// instance. Links are always looked up through the API since their names
+// needn't match the file names in their URLs.
+type gitlabProvider struct {
+	opts    *requestOptions
+	baseURL string
+	token   string
+}
+
+type gitlabRelease struct {
+	TagName    string    `json:"tag_name"`
+	ReleasedAt time.Time `json:"released_at"`
+	Assets     struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// newRequest authenticates requests to the GitLab instance only, so that the
+// token isn't sent to external asset links.
+func (p *gitlabProvider) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := p.opts.newRequest(ctx, method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if base, err := url.Parse(p.baseURL); err == nil && req.URL.Host == base.Host {
+		if p.token != "" {
+			req.Header.Set("PRIVATE-TOKEN", p.token)
+		}
+	} else {
+		p.opts.setBasicAuth(req)
+	}
+	return req, nil
+}
+
+func (p *gitlabProvider) checkAuth(resp *http.Response) error {
+	if p.token == "" {
+		return nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("GitLab rejected the token for %s (HTTP status %s); the token is invalid or lacks the read_api scope",
+			resp.Request.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+func (p *gitlabProvider) requiresAPI() bool {
+	return true
+}
+
+func (p *gitlabProvider) withToken(token string) provider {
+	c := *p
+	c.token = token
+	return &c
+}
+
+// fetchRelease looks up a release of the project owner/repo; owner may
+// include subgroups.
+func (p *gitlabProvider) fetchRelease(ctx context.Context, c *retryClient, owner, repo, tag string) (*release, error) {
+	project := url.PathEscape(owner + "/" + repo)
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/releases/permalink/latest", p.baseURL, project)
+	if tag != "" {
+		apiURL = fmt.Sprintf("%s/api/v4/projects/%s/releases/%s", p.baseURL, project, url.PathEscape(tag))
+	}
+
+	req, err := p.newRequest(ctx, "GET", apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for %s: %v", apiURL, err)
+	}
+	resp, err := c.do(slog.Default(), req)
+	if err != nil {
+		return nil, unavailable(ctx, fmt.Errorf("error requesting %s: %v", apiURL, err))
+	}
+	defer resp.Body.Close()
+
+	if err := p.checkAuth(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch release from %s: HTTP status %s", apiURL, resp.Status)
+	}
+
+	var gr gitlabRelease
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return nil, fmt.Errorf("error decoding release from %s: %v", apiURL, err)
+	}
+	r := &release{TagName: gr.TagName, PublishedAt: gr.ReleasedAt}
+	for _, link := range gr.Assets.Links {
+		r.Assets = append(r.Assets, asset{Name: link.Name, URL: cmp.Or(link.DirectAssetURL, link.URL)})
+	}
+	return r, nil
+}
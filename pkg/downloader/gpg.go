@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// gpgSignatureSuffix is appended to the name of the checksum manifest to get
+// its detached signature.
+const gpgSignatureSuffix = ".asc"
+
+// gpgVerifier verifies the detached OpenPGP signature of checksum manifests.
+type gpgVerifier struct {
+	keyring openpgp.EntityList
+}
+
+// newGPGVerifier creates a verifier for a keyring, either ASCII armored as
+// written by "gpg --export --armor" or binary.
+func newGPGVerifier(key string) (*gpgVerifier, error) {
+	var (
+		keyring openpgp.EntityList
+		err     error
+	)
+	if strings.Contains(key, "-----BEGIN PGP") {
+		keyring, err = openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(strings.NewReader(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("keyring contains no keys")
+	}
+	return &gpgVerifier{keyring}, nil
+}
+
+// verify checks that sig, armored or binary, is a valid signature of data by
+// one of the keys of the keyring.
+func (v *gpgVerifier) verify(data, sig []byte) error {
+	var err error
+	if bytes.Contains(sig, []byte("-----BEGIN PGP SIGNATURE")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(sig), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(sig), nil)
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hookTimeout is how long the post-download hook may run before it is killed,
+// so that a hanging hook doesn't block the next check.
+const hookTimeout = 5 * time.Minute
+
+// runHook runs the post-download hook command with the names of the changed
+// artefacts in CHANGED_ARTEFACTS. The command is split on whitespace and run
+// without a shell, which the distroless image doesn't have, so quotes are not
+// interpreted; a command that needs them should be wrapped in a script. The
+// hook is killed when ctx is cancelled or after hookTimeout. Failures are
+// logged but otherwise ignored.
+func runHook(ctx context.Context, command string, changed []string) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// Processes started by the hook may keep its output open after it was
+	// killed; stop waiting for them shortly after.
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(), "CHANGED_ARTEFACTS="+strings.Join(changed, ","))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	slog.Info("Running post-download hook", "event", "hook", "command", command, "artefacts", strings.Join(changed, ","))
+	err := cmd.Run()
+	if stdout.Len() > 0 {
+		slog.Info("Post-download hook output", "stdout", strings.TrimSpace(stdout.String()))
+	}
+	if stderr.Len() > 0 {
+		slog.Warn("Post-download hook output", "stderr", strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		slog.Error("Post-download hook failed", "event", "error", "command", command, "error", err)
+	}
+}
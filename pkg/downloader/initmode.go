@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// checkComplete confirms after a check that the file of every artefact exists
+// and matches its checksum, if one is known. Patterns must match at least one
+// file. Disabled artefacts are skipped like by every check, and archives that
+// were removed after extraction count as complete once a check succeeded for
+// them. Corrupt files are downloaded again by the next check.
+func checkComplete(cfg *config) error {
+	var problems []string
+	for _, src := range cfg.sources {
+		for _, a := range src.artefacts {
+			if a.Disabled || cfg.disabled.has(src, a.Name) {
+				continue
+			}
+			// The extracted files aren't known, so the check itself is
+			// the only evidence for an archive that isn't kept.
+			_, checked := src.lastChecked[a.Name]
+			extracted := checked && cfg.extractFor(a) && !cfg.keepArchive
+			specs := existingSpecs(src, a)
+			if len(specs) == 0 && !extracted {
+				problems = append(problems, src.label(a.Name)+": no file matches")
+			}
+			for _, spec := range specs {
+				artefact := src.label(spec.Name)
+				if _, err := os.Stat(src.localPath(spec)); err != nil {
+					if extracted && os.IsNotExist(err) && isArchive(spec.Name) {
+						slog.Debug("Archive was removed after extraction; counting it as complete", "event", "init", "artefact", artefact)
+						continue
+					}
+					problems = append(problems, fmt.Sprintf("%s: %v", artefact, err))
+					continue
+				}
+				ok, err := verifyFile(cfg, src, spec)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", artefact, err))
+					cfg.unverified.add(src.stateKey(spec))
+				} else if !ok {
+					slog.Warn("No checksum known for artefact; only checked that it exists", "event", "init", "artefact", artefact)
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d artefacts are missing or corrupt: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
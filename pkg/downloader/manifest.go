@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestFileName is the name of the manifest written with WRITE_MANIFEST.
+const manifestFileName = "manifest.json"
+
+// manifestEntry describes a downloaded file for downstream automation.
+type manifestEntry struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	URL     string `json:"url"`
+	Release string `json:"release,omitempty"`
+	// DownloadedAt is unknown for files downloaded before the manifest was
+	// enabled.
+	DownloadedAt *time.Time `json:"downloaded_at,omitempty"`
+}
+
+// downloadManifest lists the downloaded files in the download path.
+type downloadManifest struct {
+	path    string
+	mode    fs.FileMode
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+	dirty   bool
+}
+
+// loadManifest reads the manifest at path, so that entries of artefacts that
+// don't change survive restarts. A missing or unreadable file results in an
+// empty manifest. The manifest is written with mode.
+func loadManifest(path string, mode fs.FileMode) *downloadManifest {
+	m := &downloadManifest{path: path, mode: mode, entries: make(map[string]manifestEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read manifest; starting over", "path", path, "error", err)
+		}
+		return m
+	}
+	var file struct {
+		Files []manifestEntry `json:"files"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		slog.Warn("Failed to parse manifest; starting over", "path", path, "error", err)
+		return m
+	}
+	for _, e := range file.Files {
+		m.entries[e.File] = e
+	}
+	return m
+}
+
+// lookup returns the entry of the file key. It is false for a nil manifest.
+func (m *downloadManifest) lookup(key string) (manifestEntry, bool) {
+	if m == nil {
+		return manifestEntry{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+// update records the file of a job that was checked successfully. Unchanged
+// files that are already listed are left alone.
+func (m *downloadManifest) update(j job, url string, changed bool) {
+	key := j.src.stateKey(j.spec)
+	m.mu.Lock()
+	_, listed := m.entries[key]
+	m.mu.Unlock()
+	if listed && !changed {
+		return
+	}
+
+	localPath := j.src.localPath(j.spec)
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		// Extracted archives are removed unless KEEP_ARCHIVE is set.
+		return
+	}
+	digest, err := hashFile(localPath, sha256.New())
+	if err != nil {
+		slog.Warn("Failed to hash file for manifest", "artefact", j.src.label(j.spec.Name), "error", err)
+		return
+	}
+	e := manifestEntry{File: key, Size: fi.Size(), SHA256: hex.EncodeToString(digest), URL: url, Release: j.release}
+	if changed {
+		now := time.Now().UTC()
+		e.DownloadedAt = &now
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = e
+	m.dirty = true
+}
+
+// save drops the entries of files that no longer exist and rewrites the
+// manifest atomically if it changed.
+func (m *downloadManifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	root := filepath.Dir(m.path)
+	for key := range m.entries {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(key))); os.IsNotExist(err) {
+			delete(m.entries, key)
+			m.dirty = true
+		}
+	}
+	if !m.dirty {
+		return nil
+	}
+
+	files := make([]manifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		files = append(files, e)
+	}
+	slices.SortFunc(files, func(a, b manifestEntry) int { return strings.Compare(a.File, b.File) })
+	data, err := json.MarshalIndent(map[string]any{"files": files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := createTemp(root, manifestFileName, m.mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = replaceFile(f.Name(), m.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing manifest %s: %v", m.path, err)
+	}
+	m.dirty = false
+	return nil
+}
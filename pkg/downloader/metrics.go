@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the download duration
+// histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metrics collects download statistics and exposes them in the Prometheus
+// text format.
+type metrics struct {
+	mu             sync.Mutex
+	attempted      map[string]float64
+	succeeded      map[string]float64
+	skipped        map[string]float64
+	failed         map[string]float64
+	lastSuccess    map[string]float64
+	durationCounts []uint64
+	durationSum    float64
+	durationCount  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		attempted:      make(map[string]float64),
+		succeeded:      make(map[string]float64),
+		skipped:        make(map[string]float64),
+		failed:         make(map[string]float64),
+		lastSuccess:    make(map[string]float64),
+		durationCounts: make([]uint64, len(durationBuckets)),
+	}
+}
+
+// record updates the metrics with the outcome of downloading an artefact.
+func (m *metrics) record(artefact string, changed bool, err error, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attempted[artefact]++
+	switch {
+	case err != nil:
+		m.failed[artefact]++
+		return
+	case changed:
+		m.succeeded[artefact]++
+		seconds := duration.Seconds()
+		for i, bound := range durationBuckets {
+			if seconds <= bound {
+				m.durationCounts[i]++
+			}
+		}
+		m.durationSum += seconds
+		m.durationCount++
+	default:
+		m.skipped[artefact]++
+	}
+	m.lastSuccess[artefact] = float64(time.Now().Unix())
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeFamily(w, "artifact_downloader_downloads_attempted_total", "counter", "Number of artefact checks.", m.attempted)
+	writeFamily(w, "artifact_downloader_downloads_succeeded_total", "counter", "Number of artefacts downloaded.", m.succeeded)
+	writeFamily(w, "artifact_downloader_downloads_skipped_total", "counter", "Number of artefacts skipped because they were up to date.", m.skipped)
+	writeFamily(w, "artifact_downloader_downloads_failed_total", "counter", "Number of failed artefact checks.", m.failed)
+	writeFamily(w, "artifact_downloader_last_success_timestamp_seconds", "gauge", "Unix time of the last successful check of an artefact.", m.lastSuccess)
+
+	const name = "artifact_downloader_download_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of artefact downloads.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, m.durationCounts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.durationCount)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, m.durationSum, name, m.durationCount)
+}
+
+// writeFamily writes a metric family with one sample per artefact.
+func writeFamily(w io.Writer, name, typ, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+
+	artefacts := make([]string, 0, len(values))
+	for artefact := range values {
+		artefacts = append(artefacts, artefact)
+	}
+	sort.Strings(artefacts)
+	for _, artefact := range artefacts {
+		fmt.Fprintf(w, "%s{artefact=\"%s\"} %g\n", name, escapeLabel(artefact), values[artefact])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
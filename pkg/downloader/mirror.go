@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"text/template"
+	"time"
+)
+
+// unavailableError is a failure to reach the server an artefact is
+// downloaded from, after which the next mirror is tried.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+
+// unavailable marks err as an unavailableError unless ctx was canceled.
+func unavailable(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &unavailableError{err}
+}
+
+// download fetches an artefact if the remote copy is newer than the local one,
+// from the release or, if that fails with a network error or a server error,
+// from the mirrors of the artefact in order. An artefact with a version URL is
+// only checked once the version it serves changed. It reports whether the
+// artefact changed, or in dry-run mode whether it would have been downloaded.
+func download(ctx context.Context, cfg *config, src *source, resolve resolver, lookup manifestLookup, spec Artefact) (bool, error) {
+	logger := slog.With("artefact", src.label(spec.Name))
+	logger.Debug("Processing artefact")
+
+	var version string
+	if spec.VersionURL != "" {
+		var unchanged bool
+		if unchanged, version = checkVersion(ctx, cfg, src, logger, spec); unchanged {
+			logger.Debug("Version is unchanged; skipping", "event", "skip", "version", version)
+			return false, nil
+		}
+	}
+	changed, err := downloadMirrored(ctx, cfg, src, resolve, lookup, spec)
+	if err == nil && version != "" && !cfg.dryRun {
+		recordVersion(ctx, cfg, src, spec, version)
+	}
+	return changed, err
+}
+
+// downloadMirrored downloads an artefact from the release or, if that fails
+// with a network error or a server error, from its mirrors in order.
+func downloadMirrored(ctx context.Context, cfg *config, src *source, resolve resolver, lookup manifestLookup, spec Artefact) (bool, error) {
+	logger := slog.With("artefact", src.label(spec.Name))
+	// Without the release, e.g. during an API outage, only the mirrors are
+	// left.
+	var urls []string
+	url, err := resolve(src.tagFor(spec), spec.Name)
+	var unavailableErr *unavailableError
+	if err == nil {
+		urls = append(urls, url)
+	} else if !errors.As(err, &unavailableErr) || len(spec.Mirrors) == 0 {
+		return false, err
+	}
+	firstMirror := len(urls)
+	for _, m := range spec.Mirrors {
+		t, err := template.New("mirror").Parse(m)
+		if err != nil {
+			return false, fmt.Errorf("invalid mirror %q: %v", m, err)
+		}
+		url, err := renderURL(t, src, src.tagFor(spec), spec.Name)
+		if err != nil {
+			return false, err
+		}
+		urls = append(urls, url)
+	}
+
+	for i, url := range urls {
+		if i >= firstMirror {
+			logger.Warn("Download failed; trying next mirror", "error", err, "mirror", url)
+		}
+		var changed bool
+		changed, err = downloadVerified(ctx, cfg, src, resolve, lookup, spec, url)
+		if !errors.As(err, &unavailableErr) {
+			if err == nil && i >= firstMirror {
+				logger.Info("Mirror served the artefact", "event", "mirror", "url", url)
+			}
+			return changed, err
+		}
+	}
+	if len(urls) > 1 {
+		return false, fmt.Errorf("%v (all %d URLs failed)", err, len(urls))
+	}
+	return false, err
+}
+
+// downloadVerified downloads an artefact from url and, if the download
+// doesn't match its checksum, downloads it again up to CHECKSUM_RETRIES times,
+// backing off exponentially with jitter starting at one second. The stored
+// artefact is only replaced by a download that matches.
+func downloadVerified(ctx context.Context, cfg *config, src *source, resolve resolver, lookup manifestLookup, spec Artefact, url string) (bool, error) {
+	logger := slog.With("artefact", src.label(spec.Name))
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		changed, err := downloadFrom(ctx, cfg, src, resolve, lookup, spec, url)
+		var mismatch *checksumMismatchError
+		if !errors.As(err, &mismatch) || attempt > cfg.checksumRetries {
+			return changed, err
+		}
+		delay := backoff + rand.N(backoff/2)
+		logger.Warn("Checksum mismatch; downloading again", "event", "checksum_mismatch", "attempt", attempt,
+			"max_attempts", cfg.checksumRetries+1, "actual", mismatch.actual, "delay", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, err
+		}
+		backoff *= 2
+	}
+}
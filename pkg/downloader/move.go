@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// rename is replaceFile, replaceable to exercise moves across filesystems.
+var rename = replaceFile
+
+// moveFile renames src to dst. If they are on different filesystems, src is
+// copied to a temp file next to dst, which is then renamed over dst, so that
+// readers of dst never see a partially written file.
+func moveFile(src, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, errCrossDevice) {
+		return err
+	}
+	slog.Debug("Rename crosses filesystems; copying instead", "from", src, "to", dst)
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := createTemp(filepath.Dir(dst), filepath.Base(dst), fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	buf := buffers.Get().([]byte)
+	_, err = io.CopyBuffer(out, in, buf)
+	buffers.Put(buf)
+	if err == nil {
+		err = out.Chmod(fi.Mode().Perm())
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// A copy gets the current time, unlike a renamed file.
+		err = os.Chtimes(out.Name(), fi.ModTime(), fi.ModTime())
+	}
+	if err == nil {
+		err = rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("error copying %s to %s: %v", src, dst, err)
+	}
+	return os.Remove(src)
+}
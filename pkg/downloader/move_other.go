@@ -0,0 +1,16 @@
+//go:build !(unix || windows)
+
+package downloader
+
+import (
+	"errors"
+	"os"
+)
+
+// errCrossDevice is never returned by rename on this platform.
+var errCrossDevice = errors.New("cross-device rename")
+
+// replaceFile replaces dst with src.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "artefact")
+	dst := filepath.Join(dir, "dst", "artefact")
+	for _, d := range []string{filepath.Dir(src), filepath.Dir(dst)} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(src, []byte("new"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// The mode of the source must survive regardless of the umask.
+	if err := os.Chmod(src, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the rename of the source crosses filesystems; the copy is renamed
+	// within the destination directory.
+	rename = func(from, to string) error {
+		if from == src {
+			return errCrossDevice
+		}
+		return replaceFile(from, to)
+	}
+	t.Cleanup(func() { rename = replaceFile })
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatalf("moveFile: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("destination contains %q, want %q", data, "new")
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0640 {
+			t.Errorf("destination has mode %v, want %v", fi.Mode().Perm(), os.FileMode(0640))
+		}
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source still exists: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("destination directory holds %d entries, want only the artefact", len(entries))
+	}
+}
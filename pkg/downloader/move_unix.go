@@ -0,0 +1,16 @@
+//go:build unix
+
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// errCrossDevice is the error of renaming a file to another filesystem.
+var errCrossDevice error = syscall.EXDEV
+
+// replaceFile atomically replaces dst with src.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package downloader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceFileWhileDestinationIsOpen(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "new"), filepath.Join(dir, "artefact")
+	if err := os.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if err := replaceFile(src, dst); err != nil {
+		t.Fatalf("replaceFile: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("destination contains %q, want %q", data, "new")
+	}
+	// Readers of the old version keep reading it.
+	old, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != "old" {
+		t.Errorf("open destination reads %q, want %q", old, "old")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package downloader
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// errCrossDevice is the error of renaming a file to another volume.
+var errCrossDevice error = windows.ERROR_NOT_SAME_DEVICE
+
+// replaceFile replaces dst with src. os.Rename already uses MoveFileEx with
+// MOVEFILE_REPLACE_EXISTING, but fails while another process, such as a
+// virus scanner, an indexer, or a reader of the old version, has dst open
+// without FILE_SHARE_DELETE. Those handles are usually short-lived, so the
+// rename is retried for a few seconds.
+func replaceFile(src, dst string) error {
+	delay := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := os.Rename(src, dst)
+		if err == nil || attempt == 6 || !(errors.Is(err, windows.ERROR_ACCESS_DENIED) ||
+			errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)) {
+			return err
+		}
+		slog.Debug("Destination is in use; retrying rename", "from", src, "to", dst, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
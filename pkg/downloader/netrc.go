@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// netrcEntry is the login of a machine in a netrc file.
+type netrcEntry struct {
+	login, password string
+}
+
+// netrc holds the logins of a netrc file by host, plus the default login.
+type netrc struct {
+	machines map[string]netrcEntry
+	fallback *netrcEntry
+}
+
+// loadNetrc reads and parses the netrc file at path.
+func loadNetrc(path string) (*netrc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNetrc(string(data))
+}
+
+// parseNetrc parses the machine, default, login, and password tokens of a
+// netrc file. Macro definitions are skipped and other tokens ignored.
+func parseNetrc(data string) (*netrc, error) {
+	n := &netrc{machines: make(map[string]netrcEntry)}
+	var (
+		machine string
+		entry   *netrcEntry
+		inMacro bool
+	)
+	// add stores the entry of the previous machine or default.
+	add := func() {
+		if entry == nil {
+			return
+		}
+		if machine == "" {
+			n.fallback = entry
+		} else if _, ok := n.machines[machine]; !ok {
+			// Like curl, the first entry for a machine wins.
+			n.machines[machine] = *entry
+		}
+		entry = nil
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if inMacro {
+			// A macro definition ends at an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine", "login", "password", "account", "macdef":
+				if i+1 == len(fields) {
+					return nil, fmt.Errorf("%s without value", fields[i])
+				}
+			}
+			switch fields[i] {
+			case "machine":
+				add()
+				i++
+				machine, entry = fields[i], &netrcEntry{}
+			case "default":
+				add()
+				machine, entry = "", &netrcEntry{}
+			case "login", "password":
+				if entry == nil {
+					return nil, fmt.Errorf("%s outside of a machine entry", fields[i])
+				}
+				if fields[i] == "login" {
+					entry.login = fields[i+1]
+				} else {
+					entry.password = fields[i+1]
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	add()
+	return n, sc.Err()
+}
+
+// lookup returns the login for host, falling back to the default login if
+// fallback is set.
+func (n *netrc) lookup(host string, fallback bool) (netrcEntry, bool) {
+	if n == nil {
+		return netrcEntry{}, false
+	}
+	if e, ok := n.machines[host]; ok {
+		return e, true
+	}
+	if fallback && n.fallback != nil {
+		return *n.fallback, true
+	}
+	return netrcEntry{}, false
+}
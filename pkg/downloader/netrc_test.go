@@ -0,0 +1,69 @@
+package downloader
+
+import "testing"
+
+func TestNetrcLookup(t *testing.T) {
+	const data = `machine github.com login first password one
+machine github.com login second password two
+
+macdef init
+machine evil.example.com login macro password leaked
+
+machine gitlab.com
+  login user
+  password secret
+default login anonymous password guest
+`
+	n, err := parseNetrc(data)
+	if err != nil {
+		t.Fatalf("parseNetrc: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		fallback bool
+		want     netrcEntry
+		wantOK   bool
+	}{
+		{name: "first machine wins", host: "github.com", want: netrcEntry{"first", "one"}, wantOK: true},
+		{name: "multi-line entry", host: "gitlab.com", want: netrcEntry{"user", "secret"}, wantOK: true},
+		{name: "macro is skipped", host: "evil.example.com"},
+		{name: "macro is skipped with fallback", host: "evil.example.com", fallback: true, want: netrcEntry{"anonymous", "guest"}, wantOK: true},
+		{name: "unknown host", host: "example.com"},
+		{name: "default if enabled", host: "example.com", fallback: true, want: netrcEntry{"anonymous", "guest"}, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := n.lookup(tt.host, tt.fallback)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("lookup(%q, %v) = %+v, %v, want %+v, %v", tt.host, tt.fallback, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	var nilNetrc *netrc
+	if _, ok := nilNetrc.lookup("github.com", true); ok {
+		t.Error("lookup on a nil netrc found a login")
+	}
+}
+
+func TestParseNetrcErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "login without value", data: "machine github.com login"},
+		{name: "password without value", data: "machine github.com login user password"},
+		{name: "machine without value", data: "machine"},
+		{name: "macdef without value", data: "macdef"},
+		{name: "login outside of a machine", data: "login user password secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseNetrc(tt.data); err == nil {
+				t.Errorf("parseNetrc(%q) succeeded, want an error", tt.data)
+			}
+		})
+	}
+}
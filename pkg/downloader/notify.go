@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Outcomes of checking an artefact.
+const (
+	outcomeDownloaded = "downloaded"
+	outcomeSkipped    = "skipped"
+	outcomeFailed     = "failed"
+)
+
+// result is the outcome of checking one artefact in a cycle.
+type result struct {
+	Artefact string `json:"artefact"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+	// Tag and Size describe downloaded artefacts.
+	Tag  string `json:"tag,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// newResult describes the outcome of downloading an artefact.
+func newResult(artefact string, changed bool, err error) result {
+	switch {
+	case err != nil:
+		return result{Artefact: artefact, Outcome: outcomeFailed, Error: err.Error()}
+	case changed:
+		return result{Artefact: artefact, Outcome: outcomeDownloaded}
+	default:
+		return result{Artefact: artefact, Outcome: outcomeSkipped}
+	}
+}
+
+// notifier reports the results of a check cycle. Failures are retried like
+// downloads and then logged, but never affect the outcome of the check.
+type notifier interface {
+	notify(ctx context.Context, c *retryClient, results []result)
+}
+
+// webhook posts a summary of each check cycle to a URL.
+type webhook struct {
+	url string
+	// on is "always", "change", or "failure".
+	on string
+}
+
+// shouldNotify reports whether the results of a cycle are reported given the
+// NOTIFY_ON filter.
+func shouldNotify(on string, results []result) bool {
+	has := func(outcome string) bool {
+		return slices.ContainsFunc(results, func(r result) bool { return r.Outcome == outcome })
+	}
+	switch on {
+	case "change":
+		return has(outcomeDownloaded)
+	case "failure":
+		return has(outcomeFailed)
+	default:
+		return true
+	}
+}
+
+// notify posts the results of a cycle.
+func (w *webhook) notify(ctx context.Context, c *retryClient, results []result) {
+	if !shouldNotify(w.on, results) {
+		return
+	}
+	payload := struct {
+		Time      time.Time `json:"time"`
+		Artefacts []result  `json:"artefacts"`
+	}{time.Now().UTC(), results}
+	if err := postJSON(ctx, c, w.url, payload); err != nil {
+		slog.Error("Failed to send webhook notification", "event", "error", "error", err)
+	}
+}
+
+// postJSON posts payload as JSON to url and expects a 2xx response.
+func postJSON(ctx context.Context, c *retryClient, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.opts.userAgent)
+
+	resp, err := c.do(slog.Default(), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered with HTTP status %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the progress of a download is logged.
+const progressInterval = 5 * time.Second
+
+// progressReader logs the progress of a download while it is read.
+type progressReader struct {
+	r io.Reader
+	*progress
+}
+
+// progress is the state of a download, shared by the readers of its byte
+// ranges if it is downloaded in parallel.
+type progress struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	// offset is where a resumed download started; size is -1 if unknown.
+	offset, size int64
+	read         int64
+	start, last  time.Time
+}
+
+func newProgressReader(r io.Reader, logger *slog.Logger, offset, size int64) *progressReader {
+	now := time.Now()
+	return &progressReader{r, &progress{logger: logger, offset: offset, size: size, read: offset, start: now, last: now}}
+}
+
+// with returns a reader that adds what it reads from r to the same progress.
+func (p *progressReader) with(r io.Reader) *progressReader {
+	return &progressReader{r, p.progress}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.read += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		rate := float64(p.read-p.offset) / now.Sub(p.start).Seconds()
+		args := []any{"event", "progress", "bytes", p.read, "rate", formatSize(int64(rate)) + "/s"}
+		if p.size > 0 {
+			args = append(args, "size", p.size, "percent", p.read*100/p.size)
+		}
+		p.logger.Info("Download in progress", args...)
+	}
+	return n, err
+}
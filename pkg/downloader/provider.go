@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// provider is a service hosting releases, such as GitHub or GitLab.
+type provider interface {
+	// newRequest creates a request for url, authenticated if a token is set.
+	newRequest(ctx context.Context, method, url string) (*http.Request, error)
+	// checkAuth reports a descriptive error if the provider rejected the token.
+	checkAuth(resp *http.Response) error
+	// fetchRelease looks up the latest release or the one with the given tag.
+	// Network errors and server errors are reported as an unavailableError.
+	fetchRelease(ctx context.Context, c *retryClient, owner, repo, tag string) (*release, error)
+	// requiresAPI reports whether asset URLs must be looked up with
+	// fetchRelease rather than built from the URL template.
+	requiresAPI() bool
+	// withToken returns a copy of the provider that authenticates with token.
+	withToken(token string) provider
+}
+
+type release struct {
+	TagName     string    `json:"tag_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []asset   `json:"assets"`
+}
+
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// assetURL returns the download URL of the asset with the given name.
+func (r *release) assetURL(name string) (string, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+	return "", fmt.Errorf("asset %s not found in release %s", name, r.TagName)
+}
+
+// isPattern reports whether an artefact name is a glob pattern.
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// matchAssets returns the names of all assets of the release that match the
+// glob pattern.
+func (r *release) matchAssets(pattern string) []string {
+	var names []string
+	for _, asset := range r.Assets {
+		if ok, _ := path.Match(pattern, asset.Name); ok {
+			names = append(names, asset.Name)
+		}
+	}
+	if len(names) == 0 {
+		slog.Warn("Pattern matches no release asset", "pattern", pattern, "release", r.TagName)
+	}
+	return names
+}
+
+// requestOptions are applied to every request of a Downloader.
+type requestOptions struct {
+	// userAgent is sent with every request.
+	userAgent string
+	// disableDecompression asks servers not to apply a content coding, so
+	// that downloads are saved exactly as the remote object.
+	disableDecompression bool
+	// maxRedirects is the number of redirects followed per request.
+	maxRedirects int
+	// basicUser and basicPass are the credentials of HTTP_BASIC_USER and
+	// HTTP_BASIC_PASS, sent to all hosts except those of the provider.
+	basicUser, basicPass string
+	// headers are the headers of HTTP_HEADERS, sent to the same hosts as the
+	// basic auth credentials.
+	headers http.Header
+	// credentials are the entries of the netrc file, if any.
+	credentials *netrc
+}
+
+// checkRedirect follows up to maxRedirects redirects and stops at redirect
+// loops. Unlike Authorization, the default policy forwards the PRIVATE-TOKEN
+// header and the custom headers to other hosts, so they are dropped when a
+// redirect leaves the original host.
+func (o *requestOptions) checkRedirect(req *http.Request, via []*http.Request) error {
+	slog.Debug("Following redirect", "from", via[len(via)-1].URL.Redacted(), "to", req.URL.Redacted(), "redirects", len(via))
+	if slices.ContainsFunc(via, func(r *http.Request) bool { return r.URL.String() == req.URL.String() }) {
+		return &redirectError{fmt.Sprintf("redirect loop at %s: %s", req.URL.Redacted(), redirectChain(req, via))}
+	}
+	if len(via) > o.maxRedirects {
+		return &redirectError{fmt.Sprintf("stopped after %d redirects at %s: %s", o.maxRedirects, req.URL.Redacted(), redirectChain(req, via))}
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("PRIVATE-TOKEN")
+		for name := range o.headers {
+			if name != "Authorization" {
+				req.Header.Del(name)
+			}
+		}
+	}
+	return nil
+}
+
+// redirectError reports a redirect loop or too many redirects, which
+// retrying won't fix.
+type redirectError struct {
+	msg string
+}
+
+func (e *redirectError) Error() string {
+	return e.msg
+}
+
+// redirectChain lists the URLs of a redirected request for error messages.
+func redirectChain(req *http.Request, via []*http.Request) string {
+	var urls []string
+	for _, r := range append(via, req) {
+		urls = append(urls, r.URL.Redacted())
+	}
+	return strings.Join(urls, " -> ")
+}
+
+// sensitiveHeader reports whether the value of the header name looks like a
+// credential.
+func sensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"auth", "token", "key", "secret", "pass", "cookie", "session", "signature", "api"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders returns the headers of a request for logging, with the values
+// of sensitive headers replaced.
+func redactHeaders(h http.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for name, values := range h {
+		if sensitiveHeader(name) {
+			m[name] = "REDACTED"
+		} else {
+			m[name] = strings.Join(values, ", ")
+		}
+	}
+	return m
+}
+
+// setBasicAuth adds the custom headers and basic auth credentials to req, if
+// configured. A netrc machine entry for the host takes precedence over
+// HTTP_BASIC_USER, which in turn takes precedence over the netrc default
+// entry. Credentials take precedence over a custom Authorization header.
+func (o *requestOptions) setBasicAuth(req *http.Request) {
+	for name, values := range o.headers {
+		req.Header[name] = slices.Clone(values)
+	}
+	if e, ok := o.credentials.lookup(req.URL.Hostname(), o.basicUser == ""); ok {
+		req.SetBasicAuth(e.login, e.password)
+	} else if o.basicUser != "" {
+		req.SetBasicAuth(o.basicUser, o.basicPass)
+	}
+}
+
+// newRequest creates a request for url with our User-Agent.
+func (o *requestOptions) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", o.userAgent)
+	if o.disableDecompression {
+		// Without an Accept-Encoding header any coding is acceptable.
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	if s := spanFromContext(ctx); s != nil {
+		req.Header.Set("Traceparent", s.traceparent())
+	}
+	return req, nil
+}
@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// pruneOldVersions deletes old versions of artefacts configured as glob
+// patterns, keeping the newest keep files per pattern. Patterns are only
+// pruned when one of their artefacts was updated, and artefacts of the current
+// release are never deleted.
+func pruneOldVersions(jobs, updated []job, keep int) {
+	type key struct {
+		src     *source
+		pattern string
+	}
+	current := make(map[key][]string)
+	for _, j := range jobs {
+		if j.spec.pattern != "" {
+			k := key{j.src, j.spec.pattern}
+			current[k] = append(current[k], j.spec.Name)
+		}
+	}
+
+	pruned := make(map[key]bool)
+	for _, j := range updated {
+		k := key{j.src, j.spec.pattern}
+		if k.pattern == "" || pruned[k] {
+			continue
+		}
+		pruned[k] = true
+		if err := pruneVersions(j.src, k.pattern, current[k], keep); err != nil {
+			slog.Warn("Failed to prune old versions", "pattern", j.src.label(k.pattern), "error", err)
+		}
+	}
+}
+
+// pruneVersions deletes all but the newest keep files in the download
+// directory of src that match pattern, counting the current artefacts first.
+// Files written by the downloader itself, such as backups, are left alone.
+func pruneVersions(src *source, pattern string, current []string, keep int) error {
+	entries, err := os.ReadDir(src.downloadPath)
+	if err != nil {
+		return err
+	}
+
+	var old []fs.FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || isOwnFile(name) || slices.Contains(current, name) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			old = append(old, info)
+		}
+	}
+	slices.SortFunc(old, func(a, b fs.FileInfo) int {
+		return b.ModTime().Compare(a.ModTime())
+	})
+
+	for _, info := range old[min(max(keep-len(current), 0), len(old)):] {
+		file := filepath.Join(src.downloadPath, info.Name())
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		os.Remove(etagPath(file))
+		os.Remove(file + backupSuffix)
+		slog.Info("Deleted old version", "event", "prune", "artefact", src.label(info.Name()), "mod_time", info.ModTime())
+	}
+	return nil
+}
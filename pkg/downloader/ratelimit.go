@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all downloads, so the configured
+// bandwidth is a limit for the whole process rather than per artefact.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	rate := float64(bytesPerSecond)
+	burst := max(rate/10, 1024)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take consumes n bytes worth of tokens and returns how long the caller has
+// to wait before the bucket is no longer in debt.
+func (l *rateLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// reader wraps r so that reads are throttled by the limiter.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: l}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > int(lr.limiter.burst) {
+		p = p[:int(lr.limiter.burst)]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		time.Sleep(lr.limiter.take(n))
+	}
+	return n, err
+}
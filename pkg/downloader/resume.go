@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partialSuffix marks temp files of interrupted downloads that can be resumed.
+const partialSuffix = ".partial"
+
+// partialPath returns the temp file a download of the artefact at
+// localFilePath is written to when resuming is enabled. Unlike regular temp
+// files its name is fixed, so the next attempt can pick it up.
+func partialPath(localFilePath string) string {
+	return filepath.Join(filepath.Dir(localFilePath), tempPrefix+filepath.Base(localFilePath)+partialSuffix)
+}
+
+// validatorPath returns the file storing the ETag or Last-Modified value of
+// the response a partial download belongs to.
+func validatorPath(partial string) string {
+	return partial + ".validator"
+}
+
+// prepareResume asks for the remainder of a previously interrupted download
+// if one exists. The If-Range header makes the server send the whole
+// artefact instead if it changed in the meantime. It returns the number of
+// bytes already downloaded.
+func prepareResume(req *http.Request, partial string) int64 {
+	fi, err := os.Stat(partial)
+	if err != nil || fi.Size() == 0 {
+		return 0
+	}
+	validator, err := os.ReadFile(validatorPath(partial))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", fi.Size()))
+	req.Header.Set("If-Range", strings.TrimSpace(string(validator)))
+	return fi.Size()
+}
+
+// openPartial opens the partial download for writing. A resumed download is
+// appended to it, otherwise it is truncated and, if the server supports range
+// requests, the response's validator is stored so it can be resumed later.
+func openPartial(partial string, resp *http.Response, resumed bool) (*os.File, error) {
+	if resumed {
+		return os.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0)
+	}
+
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Weak ETags can't be used with If-Range.
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if resp.Header.Get("Accept-Ranges") == "bytes" && validator != "" {
+		err = os.WriteFile(validatorPath(partial), []byte(validator+"\n"), 0644)
+	} else {
+		err = os.Remove(validatorPath(partial))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// canResume reports whether an interrupted partial download should be kept.
+func canResume(partial string) bool {
+	_, err := os.Stat(validatorPath(partial))
+	return err == nil
+}
+
+// removePartial removes a partial download together with its validator.
+func removePartial(partial string) {
+	os.Remove(partial)
+	os.Remove(validatorPath(partial))
+}
+
+// checkContentRange verifies that a 206 response continues at offset and
+// returns the total size of the artefact, or -1 if the server didn't say.
+func checkContentRange(resp *http.Response, offset int64) (int64, error) {
+	var start, end int64
+	var total string
+	cr := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	if start != offset {
+		return 0, fmt.Errorf("server resumed at byte %d instead of %d", start, offset)
+	}
+	if total == "*" {
+		return -1, nil
+	}
+	var size int64
+	if _, err := fmt.Sscanf(total, "%d", &size); err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
+	}
+	return size, nil
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default caps of the waits of a retryClient.
+const (
+	defaultRateLimitWait = 15 * time.Minute
+	defaultRetryAfter    = 5 * time.Minute
+)
+
+// retryableStatus reports whether a request that failed with the given status
+// code may succeed when retried.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// rateLimitReset reports whether resp was rejected because the GitHub API rate
+// limit is exhausted, and when the limit resets.
+func rateLimitReset(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(reset, 0), true
+}
+
+// retryAfter returns the delay a 429 or 503 response asks for with a
+// Retry-After header, in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil && seconds >= 0 {
+		return time.Duration(min(seconds, int64(math.MaxInt64/time.Second))) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// httpDoer sends HTTP requests; *http.Client implements it.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// retryClient sends requests through an httpDoer, retrying failed ones.
+type retryClient struct {
+	doer       httpDoer
+	opts       *requestOptions
+	maxRetries int
+	// maxRateLimitWait caps how long a request waits for an exhausted GitHub
+	// API rate limit to reset before it is retried.
+	maxRateLimitWait time.Duration
+	// maxRetryAfter caps the delay of a Retry-After header that is honored,
+	// so that a hostile server can't pause us indefinitely.
+	maxRetryAfter time.Duration
+}
+
+// do performs req and retries it up to maxRetries times on network errors and
+// retryable status codes, backing off exponentially with jitter starting at
+// one second. If the GitHub API rate limit is exhausted, it waits for the
+// limit to reset instead, for at most maxRateLimitWait, and if the server
+// sent a Retry-After header, it waits exactly that long, for at most
+// maxRetryAfter.
+func (c *retryClient) do(logger *slog.Logger, req *http.Request) (*http.Response, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		var reset time.Time
+		var after time.Duration
+		var hasAfter bool
+		if attempt > 1 && req.GetBody != nil {
+			// The previous attempt consumed the body.
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := c.doer.Do(req)
+		var redirectErr *redirectError
+		if errors.As(err, &redirectErr) {
+			return nil, err
+		}
+		if err == nil {
+			if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+				logger.Info("GitHub API rate limit", "remaining", remaining,
+					"limit", resp.Header.Get("X-RateLimit-Limit"), "url", req.URL.Redacted())
+			}
+			var limited bool
+			reset, limited = rateLimitReset(resp)
+			if !limited && !retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			after, hasAfter = retryAfter(resp)
+			resp.Body.Close()
+			err = fmt.Errorf("%s %s: HTTP status %s", req.Method, req.URL.Redacted(), resp.Status)
+			if limited {
+				err = fmt.Errorf("%v; GitHub API rate limit exhausted until %s", err, reset.Format(time.RFC3339))
+			}
+		}
+
+		if attempt > c.maxRetries {
+			return nil, fmt.Errorf("%v (gave up after %d attempts)", err, attempt)
+		}
+
+		delay := backoff + rand.N(backoff/2)
+		if !reset.IsZero() {
+			// Retrying before the limit resets would only use up attempts.
+			delay = max(min(time.Until(reset)+time.Second, c.maxRateLimitWait), time.Second)
+		} else if hasAfter {
+			if after > c.maxRetryAfter {
+				logger.Warn("Server asked to retry later than allowed; retrying earlier", "retry_after", after.Round(time.Second),
+					"max_wait", c.maxRetryAfter, "url", req.URL.Redacted())
+			}
+			delay = min(after, c.maxRetryAfter)
+		}
+		logger.Warn("Request failed; retrying", "attempt", attempt, "max_attempts", c.maxRetries+1,
+			"error", err, "delay", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
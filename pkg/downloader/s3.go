@@ -0,0 +1,307 @@
+package downloader
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// s3Storage uploads artefacts to a bucket of S3 or a compatible object store
+// such as MinIO. Objects are addressed path-style, as endpoint/bucket/key,
+// which all implementations support.
+type s3Storage struct {
+	client   *retryClient
+	endpoint *url.URL
+	bucket   string
+	// prefix is prepended to the path of an artefact below root to get its key.
+	prefix string
+	root   string
+	region string
+
+	accessKey, secretKey, sessionToken string
+}
+
+// The ETag and Last-Modified time of the release asset are kept as object
+// metadata, since the object store has its own.
+const (
+	s3ETagHeader         = "X-Amz-Meta-Etag"
+	s3LastModifiedHeader = "X-Amz-Meta-Last-Modified"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// objectURL returns the URL of the object an artefact is stored as.
+func (s *s3Storage) objectURL(p string) (string, error) {
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil {
+		return "", err
+	}
+	key := path.Join(s.prefix, filepath.ToSlash(rel))
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.bucket, key)
+	u.RawPath = s3Escape(u.Path, false)
+	return u.String(), nil
+}
+
+func (s *s3Storage) stat(ctx context.Context, p string) (objectInfo, error) {
+	objectURL, err := s.objectURL(p)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	req, err := s.client.opts.newRequest(ctx, "HEAD", objectURL)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	s.sign(req, emptyPayloadHash, time.Now())
+	resp, err := s.client.do(slog.Default(), req)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return objectInfo{}, &fs.PathError{Op: "stat", Path: objectURL, Err: fs.ErrNotExist}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return objectInfo{}, fmt.Errorf("HEAD %s: HTTP status %s", objectURL, resp.Status)
+	}
+
+	info := objectInfo{Size: resp.ContentLength, ETag: resp.Header.Get(s3ETagHeader)}
+	lastModified := resp.Header.Get(s3LastModifiedHeader)
+	if lastModified == "" {
+		// Uploaded by someone else; the upload time is the best guess.
+		lastModified = resp.Header.Get("Last-Modified")
+	}
+	info.ModTime, _ = time.Parse(http.TimeFormat, lastModified)
+	return info, nil
+}
+
+// put uploads tmpFile with a single PutObject request, which replaces the
+// object atomically. tmpFile is removed afterwards, whether or not the upload
+// succeeded.
+func (s *s3Storage) put(ctx context.Context, tmpFile, p string, info objectInfo) error {
+	err := s.upload(ctx, tmpFile, p, info)
+	if rerr := os.Remove(tmpFile); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (s *s3Storage) upload(ctx context.Context, tmpFile, p string, info objectInfo) error {
+	objectURL, err := s.objectURL(p)
+	if err != nil {
+		return err
+	}
+	digest, err := hashFile(tmpFile, sha256.New())
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := s.client.opts.newRequest(ctx, "PUT", objectURL)
+	if err != nil {
+		return err
+	}
+	req.Body = f
+	req.ContentLength = fi.Size()
+	req.GetBody = func() (io.ReadCloser, error) { return os.Open(tmpFile) }
+	if info.ETag != "" {
+		req.Header.Set(s3ETagHeader, info.ETag)
+	}
+	if !info.ModTime.IsZero() {
+		req.Header.Set(s3LastModifiedHeader, info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	s.sign(req, hex.EncodeToString(digest), time.Now())
+
+	resp, err := s.client.do(slog.Default(), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return fmt.Errorf("PUT %s: HTTP status %s: %s %s", objectURL, resp.Status, e.Code, e.Message)
+	}
+	return nil
+}
+
+func (s *s3Storage) local() bool { return false }
+
+// sign adds an AWS Signature Version 4 to req, signing the host and all
+// X-Amz-* headers.
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	var params []string
+	for key, values := range query {
+		for _, v := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(v, true))
+		}
+	}
+	slices.Sort(params)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request", stringToSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(key)))
+}
+
+// s3Escape percent-encodes all but the unreserved characters of RFC 3986, as
+// Signature Version 4 requires. Slashes are kept unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// S3 configures the upload of artefacts to a bucket of S3 or a compatible
+// object store.
+type S3 struct {
+	// Endpoint defaults to the AWS endpoint of the region.
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to the keys of the objects.
+	Prefix string
+	// Region defaults to us-east-1.
+	Region string
+
+	AccessKeyID, SecretAccessKey, SessionToken string
+}
+
+// newS3Storage configures the S3 backend. Artefacts are staged in root before
+// they are uploaded.
+func newS3Storage(c *retryClient, root string, o *S3) (*s3Storage, error) {
+	s := &s3Storage{
+		client:       c,
+		bucket:       o.Bucket,
+		prefix:       strings.Trim(o.Prefix, "/"),
+		root:         root,
+		region:       cmp.Or(o.Region, "us-east-1"),
+		accessKey:    o.AccessKeyID,
+		secretKey:    o.SecretAccessKey,
+		sessionToken: o.SessionToken,
+	}
+	if s.bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("access key ID and secret access key are required")
+	}
+	endpoint := cmp.Or(o.Endpoint, "https://s3."+s.region+".amazonaws.com")
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	s.endpoint = u
+	return s, nil
+}
+
+// localOnlyFeature returns the option of a configured feature that needs the
+// artefacts in the local file system, if any.
+func localOnlyFeature(cfg *config) string {
+	switch {
+	case cfg.extract:
+		return "Extract"
+	case cfg.keepBackup:
+		return "KeepBackup"
+	case cfg.keepVersions > 0:
+		return "KeepVersions"
+	case len(cfg.symlinks) > 0:
+		return "Symlinks"
+	case cfg.contentFreshness:
+		return "ContentFreshness"
+	case cfg.manifest != nil:
+		return "WriteManifest"
+	case cfg.casDir != "":
+		return "CASDir"
+	case cfg.verifyOnStart:
+		return "VerifyOnStart"
+	}
+	for _, src := range cfg.sources {
+		for _, a := range src.artefacts {
+			if a.Extract != nil && *a.Extract {
+				return "extract of " + a.Name
+			}
+			if a.Symlink != "" {
+				return "symlink of " + a.Name
+			}
+		}
+	}
+	return ""
+}
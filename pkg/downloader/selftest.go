@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// selfTest sends one HEAD request for every artefact before the first check,
+// so that a wrong repository, URL template, or token is reported at startup
+// rather than when the check runs. It logs the outcome of every artefact and
+// returns the number of failures. Unlike a check, it only resolves the URLs:
+// releases are fetched only where the URLs depend on them, and neither the
+// backoff of failed artefacts nor the state is consulted or changed.
+func selfTest(ctx context.Context, cfg *config) int {
+	// Retrying would only delay the report.
+	c := &retryClient{doer: cfg.client.doer, opts: cfg.client.opts}
+	failed := 0
+	for _, src := range cfg.sources {
+		var specs []Artefact
+		for _, a := range src.artefacts {
+			if !a.Disabled && !cfg.disabled.has(src, a.Name) {
+				specs = append(specs, a)
+			}
+		}
+		probes, failures := probeTargets(ctx, cfg, c, src, specs)
+		failed += failures
+		for _, p := range probes {
+			artefact := src.label(p.spec.Name)
+			url, status, err := headArtefact(ctx, c, src, p.resolve, p.spec)
+			if err != nil {
+				slog.Error("Startup check failed", "event", "self_test", "artefact", artefact, "url", url, "error", err)
+				failed++
+				continue
+			}
+			slog.Info("Startup check passed", "event", "self_test", "artefact", artefact, "url", url, "status", status)
+		}
+	}
+	return failed
+}
+
+// probe is an artefact of the self-test with the resolver of its URL.
+type probe struct {
+	spec    Artefact
+	resolve resolver
+}
+
+// probeTargets returns the artefacts of src to probe, with patterns expanded
+// into the matching assets, and the number of artefacts whose release
+// couldn't be fetched. Each release is fetched at most once.
+func probeTargets(ctx context.Context, cfg *config, c *retryClient, src *source, specs []Artefact) ([]probe, int) {
+	var (
+		probes   []probe
+		failed   int
+		releases = make(map[string]*release)
+		errs     = make(map[string]error)
+	)
+	for _, a := range specs {
+		resolve := func(tag, name string) (string, error) {
+			return releaseURL(cfg, src, tag, name)
+		}
+		if !src.provider.requiresAPI() && !isPattern(a.Name) {
+			probes = append(probes, probe{a, resolve})
+			continue
+		}
+
+		tag := src.tagFor(a)
+		r, err := releases[tag], errs[tag]
+		if r == nil && err == nil {
+			if r, err = src.provider.fetchRelease(ctx, c, src.owner, src.repo, tag); err != nil {
+				errs[tag] = err
+			} else {
+				releases[tag] = r
+			}
+		}
+		if err != nil {
+			slog.Error("Startup check failed", "event", "self_test", "artefact", src.label(a.Name), "error",
+				fmt.Errorf("failed to fetch release information: %v", err))
+			failed++
+			continue
+		}
+		if src.provider.requiresAPI() {
+			resolve = func(_, name string) (string, error) { return r.assetURL(name) }
+		}
+		if !isPattern(a.Name) {
+			probes = append(probes, probe{a, resolve})
+			continue
+		}
+
+		names := r.matchAssets(a.Name)
+		if len(names) == 0 {
+			slog.Warn("No asset matches the pattern", "event", "self_test", "artefact", src.label(a.Name), "release", r.TagName)
+			if cfg.requireAll {
+				failed++
+			}
+		}
+		for _, name := range names {
+			if matchesAny(cfg.exclude, name) || cfg.isCompanion(name) || cfg.disabled.has(src, name) {
+				continue
+			}
+			match := a
+			match.Name, match.pattern = name, a.Name
+			probes = append(probes, probe{match, resolve})
+		}
+	}
+	return probes, failed
+}
+
+// headArtefact sends a HEAD request for the URL of an artefact and returns
+// the URL and the status of the response.
+func headArtefact(ctx context.Context, c *retryClient, src *source, resolve resolver, spec Artefact) (string, int, error) {
+	url, err := resolve(src.tagFor(spec), spec.Name)
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := src.provider.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return url, 0, err
+	}
+	resp, err := c.do(slog.With("artefact", src.label(spec.Name)), req)
+	if err != nil {
+		return url, 0, err
+	}
+	resp.Body.Close()
+	if err := src.provider.checkAuth(resp); err != nil {
+		return url, resp.StatusCode, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return url, resp.StatusCode, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return url, resp.StatusCode, nil
+}
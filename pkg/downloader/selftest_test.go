@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelfTest(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantFailed int
+	}{
+		{name: "available", status: http.StatusOK},
+		{name: "missing", status: http.StatusNotFound, wantFailed: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			opts := DefaultOptions()
+			opts.DownloadPath = t.TempDir()
+			opts.URLTemplate = srv.URL + "/{{.Artefact}}"
+			opts.Artefacts = []Artefact{{Name: "a.txt"}, {Name: "b.bin"}, {Name: "off", Disabled: true}}
+			// The age check needs the release, which the self-test doesn't.
+			opts.MinReleaseAge = time.Hour
+			d, err := New(opts)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			defer d.Close()
+
+			if failed := d.SelfTest(context.Background()); failed != tt.wantFailed {
+				t.Errorf("SelfTest failed %d artefacts, want %d", failed, tt.wantFailed)
+			}
+			want := []string{"HEAD /a.txt", "HEAD /b.bin"}
+			if !slices.Equal(requests, want) {
+				t.Errorf("SelfTest sent %q, want %q", requests, want)
+			}
+		})
+	}
+}
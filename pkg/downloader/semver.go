@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version such as 1.2.3-rc.1. Build metadata is ignored.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses a version with an optional leading "v". Missing minor and
+// patch numbers are zero, so "v1.2" is 1.2.0.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	core, pre, hasPre := strings.Cut(core, "-")
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		if slices.Contains(v.pre, "") {
+			return semver{}, fmt.Errorf("invalid pre-release in version %q", s)
+		}
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return semver{}, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// compare returns -1, 0, or +1 depending on whether v has a lower, the same,
+// or a higher precedence than w.
+func (v semver) compare(w semver) int {
+	if c := cmp.Or(cmp.Compare(v.major, w.major), cmp.Compare(v.minor, w.minor), cmp.Compare(v.patch, w.patch)); c != 0 {
+		return c
+	}
+	// A pre-release precedes the release.
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, errA := strconv.Atoi(v.pre[i])
+		b, errB := strconv.Atoi(w.pre[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = cmp.Compare(a, b)
+		case errA == nil:
+			// Numeric identifiers precede alphanumeric ones.
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(v.pre[i], w.pre[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.pre), len(w.pre))
+}
+
+// versionConstraint is a list of comparisons that a version must all satisfy,
+// such as ">=1.2.0 <2.0.0".
+type versionConstraint struct {
+	expr        string
+	comparisons []versionComparison
+}
+
+type versionComparison struct {
+	op      string
+	version semver
+}
+
+// parseVersionConstraint parses comparisons separated by spaces or commas.
+// Each is one of =, !=, >, >=, <, or <= followed by a version; a version
+// without an operator must match exactly.
+func parseVersionConstraint(expr string) (*versionConstraint, error) {
+	c := &versionConstraint{expr: expr}
+	fields := strings.FieldsFunc(expr, func(r rune) bool { return r == ' ' || r == ',' })
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		op := field[:len(field)-len(strings.TrimLeft(field, "=!<>"))]
+		if op == field && i+1 < len(fields) {
+			// Allow a space between operator and version, as in ">= 1.2".
+			i++
+			field += fields[i]
+		}
+		if !slices.Contains([]string{"", "=", "!=", ">", ">=", "<", "<="}, op) {
+			return nil, fmt.Errorf("invalid operator %q", op)
+		}
+		v, err := parseSemver(field[len(op):])
+		if err != nil {
+			return nil, err
+		}
+		c.comparisons = append(c.comparisons, versionComparison{cmp.Or(op, "="), v})
+	}
+	if len(c.comparisons) == 0 {
+		return nil, fmt.Errorf("empty constraint")
+	}
+	return c, nil
+}
+
+// allows reports whether v satisfies all comparisons.
+func (c *versionConstraint) allows(v semver) bool {
+	for _, comp := range c.comparisons {
+		r := v.compare(comp.version)
+		var ok bool
+		switch comp.op {
+		case "=":
+			ok = r == 0
+		case "!=":
+			ok = r != 0
+		case ">":
+			ok = r > 0
+		case ">=":
+			ok = r >= 0
+		case "<":
+			ok = r < 0
+		case "<=":
+			ok = r <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package downloader
+
+import "testing"
+
+func TestSemverCompare(t *testing.T) {
+	tests := []struct {
+		v, w string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.2", "1.2.0", 0},
+		{"1.0.0+build.1", "1.0.0", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		// The example ordering of the semver specification.
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		// Numeric identifiers precede alphanumeric ones.
+		{"1.0.0-1", "1.0.0-a", -1},
+		{"1.0.0-rc.a", "1.0.0-rc.1", 1},
+	}
+	for _, tt := range tests {
+		v, err := parseSemver(tt.v)
+		if err != nil {
+			t.Fatalf("parseSemver(%q): %v", tt.v, err)
+		}
+		w, err := parseSemver(tt.w)
+		if err != nil {
+			t.Fatalf("parseSemver(%q): %v", tt.w, err)
+		}
+		if got := v.compare(w); got != tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.v, tt.w, got, tt.want)
+		}
+	}
+}
+
+func TestParseSemverErrors(t *testing.T) {
+	for _, s := range []string{"", "latest", "1.2.3.4", "1.-2", "1.0.0-", "1.0.0-rc..1"} {
+		if _, err := parseSemver(s); err == nil {
+			t.Errorf("parseSemver(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		expr    string
+		allowed []string
+		denied  []string
+	}{
+		{expr: ">=1.2.0 <2.0.0", allowed: []string{"1.2.0", "v1.9.9", "2.0.0-rc.1"}, denied: []string{"1.1.9", "2.0.0"}},
+		{expr: ">= 1.2, < 2", allowed: []string{"1.2.0", "1.10.0"}, denied: []string{"1.1.0", "2.0.0"}},
+		{expr: "1.4.2", allowed: []string{"v1.4.2"}, denied: []string{"1.4.3"}},
+		{expr: "= 1.4", allowed: []string{"1.4.0"}, denied: []string{"1.4.1"}},
+		{expr: ">1.0.0-rc.1 !=1.0.1", allowed: []string{"1.0.0", "1.0.2"}, denied: []string{"1.0.0-rc.1", "1.0.1"}},
+		{expr: "<=1.0.0", allowed: []string{"1.0.0", "1.0.0-rc.1"}, denied: []string{"1.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := parseVersionConstraint(tt.expr)
+			if err != nil {
+				t.Fatalf("parseVersionConstraint: %v", err)
+			}
+			for _, s := range tt.allowed {
+				if v, _ := parseSemver(s); !c.allows(v) {
+					t.Errorf("%q doesn't allow %s", tt.expr, s)
+				}
+			}
+			for _, s := range tt.denied {
+				if v, _ := parseSemver(s); c.allows(v) {
+					t.Errorf("%q allows %s", tt.expr, s)
+				}
+			}
+		})
+	}
+
+	for _, expr := range []string{"", " , ", "=>1.0", "~1.2", ">=", ">= latest"} {
+		if _, err := parseVersionConstraint(expr); err == nil {
+			t.Errorf("parseVersionConstraint(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// signatureVerifier verifies the detached signature that is published next to
+// each artefact in the release.
+type signatureVerifier struct {
+	scheme string
+	// suffix is appended to the artefact name to get the signature asset.
+	suffix string
+	verify func(path string, sig []byte) error
+}
+
+// newSignatureVerifier creates a verifier for the given scheme ("cosign" or
+// "minisign") and public key.
+func newSignatureVerifier(scheme, key string) (*signatureVerifier, error) {
+	switch scheme {
+	case "cosign":
+		pub, err := parseCosignKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &signatureVerifier{scheme, ".sig", func(path string, sig []byte) error {
+			return verifyCosign(pub, path, sig)
+		}}, nil
+	case "minisign":
+		pub, keyID, err := parseMinisignKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &signatureVerifier{scheme, ".minisig", func(path string, sig []byte) error {
+			return verifyMinisign(pub, keyID, path, sig)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signature type %q; expected cosign or minisign", scheme)
+	}
+}
+
+// readKey returns the contents of the file key names, or key itself if no
+// such file exists.
+func readKey(key string) (string, error) {
+	data, err := os.ReadFile(key)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrInvalid) {
+		return key, nil
+	}
+	return string(data), err
+}
+
+// parseCosignKey parses a PEM encoded public key or certificate.
+func parseCosignKey(key string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
+
+// verifyCosign checks a signature created with "cosign sign-blob --key". The
+// signature may be base64 encoded, as cosign writes it, or raw.
+func verifyCosign(pub crypto.PublicKey, path string, sig []byte) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	if key, ok := pub.(ed25519.PublicKey); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	digest, err := hashFile(path, sha256.New())
+	if err != nil {
+		return err
+	}
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// parseMinisignKey parses a minisign public key, either the bare base64 key
+// or the contents of a minisign.pub file.
+func parseMinisignKey(key string) (ed25519.PublicKey, []byte, error) {
+	lines := minisignLines(key)
+	if len(lines) == 0 {
+		return nil, nil, errors.New("public key is empty")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, nil, errors.New("not a minisign public key")
+	}
+	return ed25519.PublicKey(raw[10:]), raw[2:10], nil
+}
+
+// verifyMinisign checks a minisign signature file, including the signature
+// of its trusted comment.
+func verifyMinisign(pub ed25519.PublicKey, keyID []byte, path string, sigFile []byte) error {
+	var sigLine, comment, globalLine string
+	for _, line := range minisignLines(string(sigFile)) {
+		if c, ok := strings.CutPrefix(line, "trusted comment: "); ok {
+			comment = c
+		} else if sigLine == "" {
+			sigLine = line
+		} else {
+			globalLine = line
+		}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(sigLine)
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("malformed minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("signature was made with key %X, expected %X", sig[2:10], keyID)
+	}
+
+	var message []byte
+	switch string(sig[:2]) {
+	case "Ed":
+		message, err = os.ReadFile(path)
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		message, err = hashFile(path, h)
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", sig[:2])
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, message, sig[10:]) {
+		return errors.New("invalid signature")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(globalLine)
+	if err != nil || !ed25519.Verify(pub, append(sig[10:], comment...), global) {
+		return errors.New("invalid signature of the trusted comment")
+	}
+	return nil
+}
+
+// minisignLines returns the non-empty lines of a minisign file without the
+// untrusted comment.
+func minisignLines(s string) []string {
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// hashFile returns the digest of the file at path.
+func hashFile(path string, h hash.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := buffers.Get().([]byte)
+	defer buffers.Put(buf)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// slackWebhook posts a message listing the artefacts downloaded in a cycle to
+// a Slack incoming webhook. Cycles without downloads aren't reported.
+type slackWebhook struct {
+	url string
+}
+
+func (s *slackWebhook) notify(ctx context.Context, c *retryClient, results []result) {
+	var lines []string
+	for _, r := range results {
+		if r.Outcome != outcomeDownloaded {
+			continue
+		}
+		line := fmt.Sprintf("• `%s`", slackEscape(r.Artefact))
+		if r.Tag != "" {
+			line += " from release *" + slackEscape(r.Tag) + "*"
+		}
+		if r.Size > 0 {
+			line += " (" + formatSize(r.Size) + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	noun := "artefacts"
+	if len(lines) == 1 {
+		noun = "artefact"
+	}
+	text := fmt.Sprintf(":package: Downloaded %d new %s:\n%s", len(lines), noun, strings.Join(lines, "\n"))
+	if err := postJSON(ctx, c, s.url, map[string]string{"text": text}); err != nil {
+		slog.Error("Failed to send Slack notification", "event", "error", "error", err)
+	}
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackEscape escapes the characters Slack treats as markup in message text.
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// formatSize formats a size in bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFileName is the name of the state file in the download path.
+const stateFileName = ".artifact-downloader-state.json"
+
+// stateEntry is what is known about the remote version of a downloaded
+// artefact.
+type stateEntry struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum,omitempty"`
+	// Version is what the version URL of the artefact served when it was
+	// last checked.
+	Version string `json:"version,omitempty"`
+}
+
+// stateStore persists the state of all artefacts, so that freshness checks
+// don't depend on file mod times, which image builds and volume restores
+// don't always preserve.
+type stateStore struct {
+	path    string
+	mode    fs.FileMode
+	mu      sync.Mutex
+	entries map[string]stateEntry
+	dirty   bool
+}
+
+// loadState reads the state file at path. A missing or unreadable file
+// results in an empty state. The state file is written with mode.
+func loadState(path string, mode fs.FileMode) *stateStore {
+	s := &stateStore{path: path, mode: mode, entries: make(map[string]stateEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read state file; falling back to file mod times", "path", path, "error", err)
+		}
+		return s
+	}
+	var file struct {
+		Artefacts map[string]stateEntry `json:"artefacts"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		slog.Warn("Failed to parse state file; falling back to file mod times", "path", path, "error", err)
+		return s
+	}
+	if file.Artefacts != nil {
+		s.entries = file.Artefacts
+	}
+	return s
+}
+
+// lookup returns the state of the artefact key if it still describes the
+// stored artefact of the given size.
+func (s *stateStore) lookup(key string, size int64) (stateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e, ok && e.Size == size
+}
+
+// get returns the state of the artefact key, whatever size it describes.
+func (s *stateStore) get(key string) (stateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+// set records the state of the artefact key.
+func (s *stateStore) set(key string, e stateEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = e
+	s.dirty = true
+}
+
+// save writes the state file if it changed since it was last written.
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(map[string]any{"artefacts": s.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := createTemp(filepath.Dir(s.path), filepath.Base(s.path), s.mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = replaceFile(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing state file %s: %v", s.path, err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// stateKey names an artefact of the source in the state file by its path
+// relative to the download path.
+func (s *source) stateKey(a Artefact) string {
+	return path.Join(s.dir, cmp.Or(a.Dest, a.Name))
+}
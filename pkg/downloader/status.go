@@ -0,0 +1,93 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// artefactState is the status of an artefact as of its last check.
+type artefactState struct {
+	Path           string     `json:"path"`
+	LastChecked    time.Time  `json:"last_checked"`
+	LastDownloaded *time.Time `json:"last_downloaded,omitempty"`
+	Size           *int64     `json:"size,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	UpToDate       bool       `json:"up_to_date"`
+}
+
+// checkState is the status of the last check.
+type checkState struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// status keeps a snapshot of the last check of every artefact, served as JSON
+// on /status.
+type status struct {
+	mu        sync.Mutex
+	lastCheck *checkState
+	artefacts map[string]*artefactState
+}
+
+func newStatus() *status {
+	return &status{artefacts: make(map[string]*artefactState)}
+}
+
+// record updates the status of an artefact with the outcome of a check.
+func (s *status) record(artefact, path string, changed, dryRun bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.artefacts[artefact]
+	if a == nil {
+		a = &artefactState{}
+		s.artefacts[artefact] = a
+	}
+	now := time.Now()
+	a.Path, a.LastChecked, a.LastError = path, now, ""
+	// In dry-run mode a changed artefact is still outdated.
+	a.UpToDate = err == nil && !(changed && dryRun)
+	if err != nil {
+		a.LastError = err.Error()
+	} else if changed && !dryRun {
+		a.LastDownloaded = &now
+	}
+}
+
+// checkDone records the end of a check that started at start.
+func (s *status) checkDone(start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = &checkState{StartedAt: start, FinishedAt: time.Now()}
+	if err != nil {
+		s.lastCheck.Error = err.Error()
+	}
+}
+
+// handler serves the status, looking up the current size of every artefact
+// in st.
+func (s *status) handler(st storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		lastCheck := s.lastCheck
+		artefacts := make(map[string]artefactState, len(s.artefacts))
+		for name, a := range s.artefacts {
+			artefacts[name] = *a
+		}
+		s.mu.Unlock()
+
+		for name, a := range artefacts {
+			if fi, err := st.stat(r.Context(), a.Path); err == nil {
+				a.Size = &fi.Size
+				artefacts[name] = a
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{"last_check": lastCheck, "artefacts": artefacts})
+	})
+}
@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// objectInfo describes a stored artefact.
+type objectInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// storage keeps the downloaded artefacts. Artefacts are addressed by their
+// local path, from which backends other than the local file system derive
+// their own names.
+type storage interface {
+	// stat returns the stored artefact at path or an error satisfying
+	// os.IsNotExist if there is none.
+	stat(ctx context.Context, path string) (objectInfo, error)
+	// put atomically replaces the artefact at path with the downloaded file
+	// tmpFile, recording the ETag and mod time of info.
+	put(ctx context.Context, tmpFile, path string, info objectInfo) error
+	// local reports whether artefacts end up in the local file system, which
+	// is required for extraction, backups, symlinks, and pruning.
+	local() bool
+}
+
+// localStorage keeps artefacts in the download path. The ETag and mod time are
+// stored in the sidecar file and set by the caller.
+type localStorage struct{}
+
+func (localStorage) stat(_ context.Context, path string) (objectInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	return objectInfo{Size: fi.Size(), ModTime: fi.ModTime(), ETag: readETag(path)}, nil
+}
+
+func (localStorage) put(_ context.Context, tmpFile, path string, _ objectInfo) error {
+	return moveFile(tmpFile, path)
+}
+
+func (localStorage) local() bool { return true }
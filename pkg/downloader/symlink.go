@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// symlinkFor returns the path of the latest symlink of an artefact, if one
+// is configured. Relative paths are relative to the download path of src.
+func (cfg *config) symlinkFor(src *source, a Artefact) string {
+	link := cmp.Or(a.Symlink, cfg.symlinks[cmp.Or(a.pattern, a.Name)])
+	if link == "" || filepath.IsAbs(link) {
+		return link
+	}
+	return filepath.Join(src.downloadPath, filepath.FromSlash(link))
+}
+
+// updateSymlinks points the latest symlinks at the artefacts downloaded in
+// this check. If several artefacts share a link, the newest one wins.
+func updateSymlinks(cfg *config, updated []job) {
+	targets := make(map[string]string)
+	var links []string
+	for _, j := range updated {
+		link := cfg.symlinkFor(j.src, j.spec)
+		if link == "" {
+			continue
+		}
+		target := j.src.localPath(j.spec)
+		if prev, ok := targets[link]; !ok {
+			links = append(links, link)
+		} else if !newerFile(target, prev) {
+			continue
+		}
+		targets[link] = target
+	}
+
+	for _, link := range links {
+		if err := replaceSymlink(targets[link], link, cfg.dirMode); err != nil {
+			slog.Error("Failed to update symlink", "event", "error", "link", link, "error", err)
+			continue
+		}
+		slog.Info("Updated symlink", "event", "symlink", "link", link, "target", targets[link])
+	}
+}
+
+// newerFile reports whether the file a was modified after the file b.
+func newerFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err != nil || fa.ModTime().After(fb.ModTime())
+}
+
+// replaceSymlink atomically points link at target. The temporary link is
+// created next to link rather than in the download path, so that the rename
+// stays on one filesystem.
+func replaceSymlink(target, link string, dirMode fs.FileMode) error {
+	if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("refusing to replace %s, which is not a symlink", link)
+	}
+	dir := filepath.Dir(link)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	// Relative links keep working if the directory is mounted elsewhere.
+	dest := target
+	if abs, err := filepath.Abs(target); err == nil {
+		dest = abs
+		if absDir, err := filepath.Abs(dir); err == nil {
+			if rel, err := filepath.Rel(absDir, abs); err == nil && filepath.IsLocal(rel) {
+				dest = rel
+			}
+		}
+	}
+
+	tmp := filepath.Join(dir, tempPrefix+filepath.Base(link)+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	if err := os.Symlink(dest, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempPrefix is the name prefix of temp files that downloads are written to
+// before being renamed into place.
+const tempPrefix = ".tmp-"
+
+// createTemp creates a uniquely named temp file in dir for an artefact, with
+// the given mode.
+func createTemp(dir, artefact string, mode fs.FileMode) (*os.File, error) {
+	f, err := os.CreateTemp(dir, tempPrefix+artefact+"-*")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp uses 0600, but the artefact should keep the configured mode.
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// cleanupTempFiles removes temp files left behind in dir by runs that crashed
+// or were killed mid-download. Partial downloads are kept if keepPartials is
+// set so that they can be resumed, and files modified within maxAge are kept
+// because another instance sharing the directory may still write them.
+func cleanupTempFiles(dir string, keepPartials bool, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to scan for stale temp files", "path", dir, "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, tempPrefix) {
+			continue
+		}
+		if keepPartials && (strings.HasSuffix(name, partialSuffix) || strings.HasSuffix(name, validatorPath(partialSuffix))) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) < maxAge {
+			slog.Info("Keeping recent temp file", "path", path, "mod_time", info.ModTime())
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to remove stale temp file", "path", path, "error", err)
+			continue
+		}
+		slog.Info("Removed stale temp file", "path", path)
+	}
+}
@@ -0,0 +1,42 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader wraps a response body and aborts the request through cancel
+// once no data arrived for the configured timeout. Unlike a deadline for the
+// whole transfer this does not penalize large artefacts on slow links.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		cancel()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.stalled.Load() {
+		return n, fmt.Errorf("no data received for %s", s.timeout)
+	}
+	s.timer.Reset(s.timeout)
+	return n, err
+}
+
+// Stop releases the timer. It must be called once reading is finished.
+func (s *stallReader) Stop() {
+	s.timer.Stop()
+}
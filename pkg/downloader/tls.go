@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// tlsOptions configures the TLS settings of the HTTP client.
+type tlsOptions struct {
+	caFile             string
+	clientCert         string
+	clientKey          string
+	insecureSkipVerify bool
+	// insecureHosts are the host names whose certificates aren't verified.
+	insecureHosts []string
+}
+
+// tlsConfig builds the client's TLS configuration. Certificates from caFile
+// are trusted in addition to the system roots.
+func (o tlsOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+
+	if o.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.clientCert != "" || o.clientKey != "" {
+		if o.clientCert == "" || o.clientKey == "" {
+			return nil, fmt.Errorf("both a client certificate and a client key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(o.clientCert, o.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.insecureHosts) > 0 && !o.insecureSkipVerify {
+		// The default verification can't be skipped per host, so it is
+		// replaced by the same checks for all other hosts.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if slices.Contains(o.insecureHosts, strings.ToLower(cs.ServerName)) {
+				return nil
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         cfg.RootCAs,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+				return fmt.Errorf("failed to verify certificate: %v", err)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
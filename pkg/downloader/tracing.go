@@ -0,0 +1,220 @@
+package downloader
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPendingSpans caps the spans buffered between exports.
+const maxPendingSpans = 4096
+
+// otlpTracer records spans and exports them in the OTLP/HTTP JSON format
+// after every check. It is nil unless tracing is configured, and all span
+// methods are no-ops then.
+type otlpTracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	version  string
+
+	mu      sync.Mutex
+	pending []*span
+}
+
+// Tracing configures the export of traces to an OpenTelemetry collector.
+type Tracing struct {
+	// Endpoint is the URL spans are posted to, such as
+	// http://collector:4318/v1/traces.
+	Endpoint string
+	// Headers are sent with every export, e.g. for authentication.
+	Headers map[string]string
+	// ServiceName defaults to artifact-downloader.
+	ServiceName    string
+	ServiceVersion string
+}
+
+// newOTLPTracer returns a tracer exporting to the endpoint of t, or nil if t
+// is nil.
+func newOTLPTracer(t *Tracing) (*otlpTracer, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(t.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q", t.Endpoint)
+	}
+	return &otlpTracer{
+		endpoint: t.Endpoint,
+		headers:  t.Headers,
+		service:  cmp.Or(t.ServiceName, "artifact-downloader"),
+		version:  t.ServiceVersion,
+	}, nil
+}
+
+// span is a traced operation such as a check or a download.
+type span struct {
+	tracer   *otlpTracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	err      error
+}
+
+type spanKey struct{}
+
+// startSpan starts a span as a child of the span in ctx, if any, and returns
+// a context carrying it. Without a tracer it returns ctx and a nil span.
+func (t *otlpTracer) startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now(), attrs: make(map[string]any)}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// spanFromContext returns the span carried by ctx, or nil.
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// set records an attribute of the span. Spans are only modified by the
+// goroutine that started them.
+func (s *span) set(key string, value any) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// finish ends the span, marking it as failed if err is not nil, and queues it
+// for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	if len(s.tracer.pending) < maxPendingSpans {
+		s.tracer.pending = append(s.tracer.pending, s)
+	}
+}
+
+// traceparent returns the W3C trace context header of the span.
+func (s *span) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// export sends the finished spans to the collector. Failures are logged and
+// the spans dropped, so that tracing never affects downloads.
+func (t *otlpTracer) export(ctx context.Context, c *retryClient) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if err := t.post(ctx, c, spans); err != nil {
+		slog.Warn("Failed to export traces", "endpoint", t.endpoint, "spans", len(spans), "error", err)
+	}
+}
+
+func (t *otlpTracer) post(ctx context.Context, c *retryClient, spans []*span) error {
+	type kv = map[string]any
+	otlpSpans := make([]kv, 0, len(spans))
+	for _, s := range spans {
+		o := kv{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			o["status"] = kv{"code": 2, "message": s.err.Error()} // STATUS_CODE_ERROR
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+	payload := kv{"resourceSpans": []kv{{
+		"resource": kv{"attributes": otlpAttributes(map[string]any{"service.name": t.service, "service.version": t.version})},
+		"scopeSpans": []kv{{
+			"scope": kv{"name": "artifact-downloader", "version": t.version},
+			"spans": otlpSpans,
+		}},
+	}}}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.opts.userAgent)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.do(slog.Default(), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered with HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes to OTLP key-value pairs.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	list := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]any{"key": k, "value": value})
+	}
+	return list
+}
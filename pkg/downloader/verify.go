@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// unverifiedSet holds the artefacts whose existing files failed verification
+// with VERIFY_ON_START. They are downloaded unconditionally until a download
+// succeeds.
+type unverifiedSet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// has reports whether the artefact key failed verification. It is false for
+// a nil set.
+func (u *unverifiedSet) has(key string) bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.keys[key]
+}
+
+// add records that the artefact key failed verification.
+func (u *unverifiedSet) add(key string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.keys[key] = true
+}
+
+// done records that the artefact key was downloaded again.
+func (u *unverifiedSet) done(key string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.keys, key)
+}
+
+// verifyExisting hashes the existing file of every artefact and compares it
+// against its configured checksum, or else against the digest recorded in the
+// state file or the manifest when it was downloaded. Files that don't match
+// are returned, so that the first check downloads them again regardless of
+// their validators.
+func verifyExisting(cfg *config) *unverifiedSet {
+	u := &unverifiedSet{keys: make(map[string]bool)}
+	var passed, unknown int
+	for _, src := range cfg.sources {
+		for _, a := range src.artefacts {
+			for _, spec := range existingSpecs(src, a) {
+				key := src.stateKey(spec)
+				logger := slog.With("artefact", src.label(spec.Name), "event", "verify")
+				ok, err := verifyFile(cfg, src, spec)
+				switch {
+				case err != nil:
+					logger.Warn("Existing file failed verification; downloading it again", "error", err)
+					u.keys[key] = true
+				case ok:
+					logger.Info("Verified existing file")
+					passed++
+				default:
+					unknown++
+				}
+			}
+		}
+	}
+	slog.Info("Verified existing files", "event", "verify", "passed", passed, "failed", len(u.keys), "unverifiable", unknown)
+	return u
+}
+
+// existingSpecs returns a for a plain artefact, and an artefact for every
+// existing file matching a glob pattern, except for the files written by the
+// downloader itself.
+func existingSpecs(src *source, a Artefact) []Artefact {
+	if !isPattern(a.Name) {
+		return []Artefact{a}
+	}
+	var specs []Artefact
+	matches, _ := filepath.Glob(filepath.Join(src.downloadPath, a.Name))
+	for _, m := range matches {
+		if isOwnFile(filepath.Base(m)) {
+			continue
+		}
+		match := a
+		match.Name, match.pattern = filepath.Base(m), a.Name
+		specs = append(specs, match)
+	}
+	return specs
+}
+
+// isOwnFile reports whether a file in a download directory was written by
+// the downloader rather than downloaded: hidden temp, sidecar, state, and
+// lock files, backups, and the manifest.
+func isOwnFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, backupSuffix) ||
+		strings.HasSuffix(name, partialSuffix) || name == manifestFileName
+}
+
+// verifyFile checks the existing file of an artefact. It reports false
+// without an error if the file doesn't exist or no digest is known.
+func verifyFile(cfg *config, src *source, spec Artefact) (bool, error) {
+	localPath := src.localPath(spec)
+	fi, err := os.Stat(localPath)
+	if err != nil || fi.IsDir() {
+		return false, nil
+	}
+	key := src.stateKey(spec)
+
+	// A size other than the downloaded one is a mismatch.
+	expected := cfg.checksumFor(spec)
+	if expected == "" {
+		if state, ok := cfg.state.get(key); ok {
+			if state.Size != fi.Size() {
+				return false, fmt.Errorf("size is %d bytes, but %d bytes were downloaded", fi.Size(), state.Size)
+			}
+			expected = state.Checksum
+		}
+	}
+	if expected == "" {
+		if e, ok := cfg.manifest.lookup(key); ok {
+			if e.Size != fi.Size() {
+				return false, fmt.Errorf("size is %d bytes, but %d bytes were downloaded", fi.Size(), e.Size)
+			}
+			expected = e.SHA256
+		}
+	}
+	if expected == "" {
+		slog.Debug("No checksum known for existing file; skipping verification", "artefact", src.label(spec.Name), "event", "verify")
+		return false, nil
+	}
+	if _, err := verifyChecksum(localPath, expected); err != nil {
+		return false, err
+	}
+	return true, nil
+}
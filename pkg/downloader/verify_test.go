@@ -0,0 +1,34 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExistingSpecs(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"tool-1", "tool-2",
+		".tool-1.etag", "tool-1" + backupSuffix, tempPrefix + "tool-2" + partialSuffix,
+		tempPrefix + "tool-2" + partialSuffix + ".validator", ".lock", stateFileName, manifestFileName,
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := &source{downloadPath: dir}
+	var names []string
+	for _, spec := range existingSpecs(src, Artefact{Name: "*"}) {
+		if spec.pattern != "*" {
+			t.Errorf("%s has pattern %q, want %q", spec.Name, spec.pattern, "*")
+		}
+		names = append(names, spec.Name)
+	}
+	if want := []string{"tool-1", "tool-2"}; !slices.Equal(names, want) {
+		t.Errorf("existingSpecs matched %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// maxVersionSize is the most read from a version URL; anything beyond it is
+// unlikely to be a version string.
+const maxVersionSize = 4 * 1024
+
+// fetchVersion renders the version URL of an artefact and returns the
+// whitespace-trimmed contents it serves.
+func fetchVersion(ctx context.Context, cfg *config, src *source, logger *slog.Logger, spec Artefact) (string, error) {
+	t, err := template.New("version").Parse(spec.VersionURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid version URL %q: %v", spec.VersionURL, err)
+	}
+	url, err := renderURL(t, src, src.tagFor(spec), spec.Name)
+	if err != nil {
+		return "", err
+	}
+	req, err := src.provider.newRequest(ctx, "GET", url)
+	if err != nil {
+		return "", fmt.Errorf("error creating request for version URL %s: %v", url, err)
+	}
+	resp, err := cfg.client.do(logger, req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching version URL %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if err := src.provider.checkAuth(resp); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch version URL %s: HTTP status %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVersionSize+1))
+	if err != nil {
+		return "", fmt.Errorf("error reading version URL %s: %v", url, err)
+	}
+	if len(body) > maxVersionSize {
+		return "", fmt.Errorf("version URL %s serves more than %d bytes", url, maxVersionSize)
+	}
+	version := strings.TrimSpace(string(body))
+	if version == "" {
+		return "", fmt.Errorf("version URL %s is empty", url)
+	}
+	return version, nil
+}
+
+// checkVersion fetches the version of an artefact with a version URL. It
+// reports whether the version is the one recorded for the stored artefact,
+// in which case the artefact itself needn't be checked, and the version to
+// record once it was. A version URL that can't be fetched falls back to
+// checking the artefact.
+func checkVersion(ctx context.Context, cfg *config, src *source, logger *slog.Logger, spec Artefact) (unchanged bool, version string) {
+	version, err := fetchVersion(ctx, cfg, src, logger, spec)
+	if err != nil {
+		logger.Warn("Failed to fetch version; checking the artefact instead", "error", err)
+		return false, ""
+	}
+	key := src.stateKey(spec)
+	stored, ok := cfg.state.get(key)
+	if !ok || stored.Version != version || cfg.unverified.has(key) {
+		logger.Debug("Version changed", "version", version, "previous", stored.Version)
+		return false, version
+	}
+	if _, err := cfg.storage.stat(ctx, src.localPath(spec)); err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to check stored artefact", "error", err)
+		}
+		return false, version
+	}
+	return true, version
+}
+
+// recordVersion stores the version an artefact was checked at in the state.
+// Without an entry for the artefact, e.g. for a file downloaded before the
+// state file existed, one is created from the stored file, so that its size
+// and mod time still describe it.
+func recordVersion(ctx context.Context, cfg *config, src *source, spec Artefact, version string) {
+	key := src.stateKey(spec)
+	e, ok := cfg.state.get(key)
+	if !ok {
+		fi, err := cfg.storage.stat(ctx, src.localPath(spec))
+		if err != nil {
+			// An archive removed after extraction leaves nothing to describe.
+			if !os.IsNotExist(err) {
+				slog.Warn("Failed to record version", "artefact", src.label(spec.Name), "version", version, "error", err)
+			}
+			return
+		}
+		e = stateEntry{ETag: fi.ETag, LastModified: fi.ModTime, Size: fi.Size}
+	}
+	if ok && e.Version == version {
+		return
+	}
+	e.Version = version
+	cfg.state.set(key, e)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startServer listens on addr and serves handler in the background.
+func startServer(addr string, handler http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("HTTP server listening", "addr", ln.Addr().String())
+	return srv, nil
+}
+
+// stopServer shuts srv down, giving in-flight requests a few seconds to finish.
+func stopServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Error shutting down HTTP server", "error", err)
+	}
+}
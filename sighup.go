@@ -0,0 +1,14 @@
+//go:build !js && !wasip1
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload relays SIGHUP, which triggers an immediate check, to c.
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
@@ -0,0 +1,9 @@
+//go:build js || wasip1
+
+package main
+
+import "os"
+
+// notifyReload does nothing, as there is no SIGHUP on this platform; checks
+// can still be triggered via HTTP.
+func notifyReload(c chan<- os.Signal) {}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// trigger serves POST /trigger, which requests an immediate check like
+// SIGHUP does.
+type trigger struct {
+	// running is set while a check runs.
+	running atomic.Bool
+	// C receives the requested checks; it holds at most one pending request.
+	C chan struct{}
+}
+
+func newTrigger() *trigger {
+	return &trigger{C: make(chan struct{}, 1)}
+}
+
+func (t *trigger) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /trigger", func(w http.ResponseWriter, _ *http.Request) {
+		if t.running.Load() {
+			http.Error(w, "check already running", http.StatusConflict)
+			return
+		}
+		select {
+		case t.C <- struct{}{}:
+		default:
+			// A check is already pending.
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("check triggered\n"))
+	})
+}
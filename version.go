@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionInfo describes the running build.
+func versionInfo() string {
+	return fmt.Sprintf("artifact-downloader %s (commit %s, built %s, %s)", version, commit, buildDate, runtime.Version())
+}
+
+// printVersion reports whether the version was requested with --version, -v,
+// or PRINT_VERSION.
+func printVersion() bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "--version" || arg == "-v" || arg == "-version" {
+			return true
+		}
+	}
+	v, _ := strconv.ParseBool(os.Getenv("PRINT_VERSION"))
+	return v
+}